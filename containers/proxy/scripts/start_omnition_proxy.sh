@@ -8,21 +8,109 @@ export OBS_TRACING_DRIVER=$TRACING_DRIVER
 export OBS_TRACING_HOST=$TRACING_HOST
 export OBS_TRACING_PORT=$TRACING_PORT
 export OBS_TRACING_TAG_HEADERS=$TRACING_TAG_HEADERS
+export OBS_TRACING_SAMPLING=$TRACING_SAMPLING
+export OBS_RUNTIME_DIR=$RUNTIME_DIR
 
 export OBS_TLS_ENABLED=$TLS_ENABLED
 export OBS_TLS_CERT=$TLS_CERT
 export OBS_TLS_KEY=$TLS_KEY
 export OBS_TLS_CA_CERT=$TLS_CA_CERT
+export OBS_TLS_CERT_FILE=$TLS_CERT_FILE
+export OBS_TLS_KEY_FILE=$TLS_KEY_FILE
+export OBS_TLS_CA_CERT_FILE=$TLS_CA_CERT_FILE
+
+export OBS_OPTIONS_FILE=$OPTIONS_FILE
 
 export OBS_ADMIN_PORT=$ADMIN_PORT
 export OBS_ADMIN_LOG_PATH=$ADMIN_LOG_PATH
+export OBS_ADMIN_ADDRESS=$ADMIN_ADDRESS
+export OBS_METRICS_PORT=$METRICS_PORT
 
 export OBS_INGRESS_PORT=$INGRESS_PORT
 export OBS_EGRESS_PORT=$EGRESS_PORT
 
-export OBS_TIMEOUT=$TIMEOUT
+export OBS_SCHEMA_VERSION=$SCHEMA_VERSION
 
-export SERVICE_NAME=${SERVICE_NAME:-'unknown-service'}
+export OBS_INGRESS_TIMEOUT=$INGRESS_TIMEOUT
+# Deprecated, replaced by INGRESS_TIMEOUT
+export OBS_TIMEOUT=$TIMEOUT
+export OBS_EGRESS_TIMEOUT=$EGRESS_TIMEOUT
+export OBS_INGRESS_TIMEOUT_RULES=$INGRESS_TIMEOUT_RULES
+export OBS_EGRESS_TIMEOUT_RULES=$EGRESS_TIMEOUT_RULES
+
+export OBS_EGRESS_RETRY_ON=$EGRESS_RETRY_ON
+export OBS_EGRESS_RETRY_NUM_RETRIES=$EGRESS_RETRY_NUM_RETRIES
+export OBS_EGRESS_RETRY_PER_TRY_TIMEOUT=$EGRESS_RETRY_PER_TRY_TIMEOUT
+export OBS_EGRESS_RETRY_BACKOFF_BASE=$EGRESS_RETRY_BACKOFF_BASE
+export OBS_EGRESS_RETRY_BACKOFF_MAX=$EGRESS_RETRY_BACKOFF_MAX
+export OBS_EGRESS_RETRY_HOSTS=$EGRESS_RETRY_HOSTS
+export OBS_EGRESS_RETRY_PORTS=$EGRESS_RETRY_PORTS
+
+export OBS_INGRESS_MAX_CONNECTIONS=$INGRESS_MAX_CONNECTIONS
+export OBS_INGRESS_MAX_PENDING_REQUESTS=$INGRESS_MAX_PENDING_REQUESTS
+export OBS_INGRESS_MAX_REQUESTS=$INGRESS_MAX_REQUESTS
+export OBS_INGRESS_MAX_RETRIES=$INGRESS_MAX_RETRIES
+
+export OBS_EGRESS_MAX_CONNECTIONS=$EGRESS_MAX_CONNECTIONS
+export OBS_EGRESS_MAX_PENDING_REQUESTS=$EGRESS_MAX_PENDING_REQUESTS
+export OBS_EGRESS_MAX_REQUESTS=$EGRESS_MAX_REQUESTS
+export OBS_EGRESS_MAX_RETRIES=$EGRESS_MAX_RETRIES
+
+export OBS_INGRESS_OUTLIER_CONSECUTIVE_5XX=$INGRESS_OUTLIER_CONSECUTIVE_5XX
+export OBS_INGRESS_OUTLIER_INTERVAL=$INGRESS_OUTLIER_INTERVAL
+export OBS_INGRESS_OUTLIER_BASE_EJECTION_TIME=$INGRESS_OUTLIER_BASE_EJECTION_TIME
+export OBS_INGRESS_OUTLIER_MAX_EJECTION_PERCENT=$INGRESS_OUTLIER_MAX_EJECTION_PERCENT
+
+export OBS_EGRESS_OUTLIER_CONSECUTIVE_5XX=$EGRESS_OUTLIER_CONSECUTIVE_5XX
+export OBS_EGRESS_OUTLIER_INTERVAL=$EGRESS_OUTLIER_INTERVAL
+export OBS_EGRESS_OUTLIER_BASE_EJECTION_TIME=$EGRESS_OUTLIER_BASE_EJECTION_TIME
+export OBS_EGRESS_OUTLIER_MAX_EJECTION_PERCENT=$EGRESS_OUTLIER_MAX_EJECTION_PERCENT
+
+export OBS_CLUSTER_CONNECT_TIMEOUT=$CLUSTER_CONNECT_TIMEOUT
+export OBS_CLUSTER_IDLE_TIMEOUT=$CLUSTER_IDLE_TIMEOUT
+export OBS_CLUSTER_KEEPALIVE_PROBES=$CLUSTER_KEEPALIVE_PROBES
+export OBS_CLUSTER_KEEPALIVE_TIME=$CLUSTER_KEEPALIVE_TIME
+export OBS_CLUSTER_KEEPALIVE_INTERVAL=$CLUSTER_KEEPALIVE_INTERVAL
+export OBS_CLUSTER_CLEANUP_INTERVAL=$CLUSTER_CLEANUP_INTERVAL
+
+export OBS_HTTP2_MAX_CONCURRENT_STREAMS=$HTTP2_MAX_CONCURRENT_STREAMS
+export OBS_HTTP2_INITIAL_STREAM_WINDOW_SIZE=$HTTP2_INITIAL_STREAM_WINDOW_SIZE
+export OBS_HTTP2_INITIAL_CONNECTION_WINDOW_SIZE=$HTTP2_INITIAL_CONNECTION_WINDOW_SIZE
+export OBS_HTTP2_UPSTREAM_PROTOCOL=$HTTP2_UPSTREAM_PROTOCOL
+export OBS_UPSTREAM_PROTOCOL_PORTS=$UPSTREAM_PROTOCOL_PORTS
+
+export OBS_HTTP1_ACCEPT_HTTP_10=$HTTP1_ACCEPT_HTTP_10
+export OBS_HTTP1_DEFAULT_HOST_FOR_HTTP_10=$HTTP1_DEFAULT_HOST_FOR_HTTP_10
+export OBS_HTTP1_ALLOW_ABSOLUTE_URL=$HTTP1_ALLOW_ABSOLUTE_URL
+export OBS_HTTP1_HEADER_KEY_FORMAT=$HTTP1_HEADER_KEY_FORMAT
+
+export OBS_DRAIN_TYPE=$DRAIN_TYPE
+export OBS_DRAIN_TIMEOUT=$DRAIN_TIMEOUT
+
+export OBS_ACCESS_LOG_PATH=$ACCESS_LOG_PATH
+export OBS_ACCESS_LOG_FORMAT=$ACCESS_LOG_FORMAT
+export OBS_ACCESS_LOG_FILTER=$ACCESS_LOG_FILTER
+export OBS_ACCESS_LOG_SAMPLE_PERCENT=$ACCESS_LOG_SAMPLE_PERCENT
+
+export OBS_STATS_SINK=$STATS_SINK
+export OBS_STATS_SINK_ADDRESS=$STATS_SINK_ADDRESS
+export OBS_STATS_SINK_PORT=$STATS_SINK_PORT
+export OBS_STATS_SINK_PREFIX=$STATS_SINK_PREFIX
+
+export OBS_SERVICE_NAME=$SERVICE_NAME
+export OBS_SERVICE_NAMESPACE=$SERVICE_NAMESPACE
+export OBS_SERVICE_VERSION=$SERVICE_VERSION
+
+export OBS_NODE_ID=$NODE_ID
+export OBS_NODE_REGION=$NODE_REGION
+export OBS_NODE_ZONE=$NODE_ZONE
+export OBS_NODE_SUB_ZONE=$NODE_SUB_ZONE
+export OBS_NODE_METADATA=$NODE_METADATA
+
+export OBS_DOWNWARD_LABELS_PATH=$DOWNWARD_LABELS_PATH
+export OBS_DOWNWARD_ANNOTATIONS_PATH=$DOWNWARD_ANNOTATIONS_PATH
+export OBS_DOWNWARD_TAG_LABELS=$DOWNWARD_TAG_LABELS
+export OBS_DOWNWARD_TAG_ANNOTATIONS=$DOWNWARD_TAG_ANNOTATIONS
 
 
 # TODO(owais): Test system wide CA cert approval 
@@ -64,7 +152,7 @@ if [ $1 = "show-config" ];
 elif [ $1 = "run" ]
   then
   echo "starting envoy"
-  sg omnition-proxy -c "envoy -c /etc/envoy.yaml -l info --service-cluster $SERVICE_NAME"
+  exec observer run -config /etc/envoy.yaml -group omnition-proxy -mode "${CONFIG_MODE:-static}"
 else
   $1
 fi
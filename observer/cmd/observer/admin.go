@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/admin"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+)
+
+// adminFlag registers the flag shared by commands talking to the admin API.
+func adminFlag(flags *flag.FlagSet) *string {
+	return flags.String("admin-url", "", "admin API of the envoy to use, defaults to the configured admin port")
+}
+
+// newAdminClient returns a client for adminURL or, when it is empty, for
+// the configured admin port.
+func newAdminClient(adminURL string) (*admin.Client, error) {
+	if adminURL == "" {
+		opts, err := buildOptions()
+		if err != nil {
+			return nil, err
+		}
+		if err := applyAdminEnv(&opts); err != nil {
+			return nil, err
+		}
+		adminURL = envoy.AdminURL(opts)
+	}
+	return admin.New(adminURL), nil
+}
+
+// applyAdminEnv reads the admin address and port from ADMIN_ADDRESS and
+// ADMIN_PORT when their OBS_ names are not set. The proxy's start script
+// only exports the OBS_ names to the observer it starts, so commands run
+// with kubectl exec or from container hooks only see the plain names.
+func applyAdminEnv(opts *options.Options) error {
+	if os.Getenv("OBS_ADMIN_ADDRESS") == "" {
+		if address := os.Getenv("ADMIN_ADDRESS"); address != "" {
+			opts.AdminAddress = address
+		}
+	}
+	if os.Getenv("OBS_ADMIN_PORT") == "" {
+		if value := os.Getenv("ADMIN_PORT"); value != "" {
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return merry.Errorf("invalid value [%s] for ADMIN_PORT: expected an integer", value)
+			}
+			opts.AdminPort = port
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/drift"
+	log "github.com/sirupsen/logrus"
+)
+
+// runDiff compares the config of the running Envoy with the config
+// generated for the current options and fails when they drifted apart. With
+// -ignore-rejected, drift is tolerated while `observer run` keeps the last
+// good config because it rejected the config generated for the current
+// options, since restarting the proxy would not apply them either.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	live := flags.Bool("live", false, "compare with the config of the running envoy")
+	ignoreRejected := flags.Bool("ignore-rejected", false, "do not fail on drift while observer run keeps the last good config")
+	configPath := flags.String("config", "/etc/envoy.yaml", "path observer run writes the envoy config to")
+	adminURL := adminFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !*live {
+		return merry.New("usage: observer diff -live [-ignore-rejected] [-config path] [-admin-url url]")
+	}
+
+	generated, err := generateCheckedConfig()
+	if err != nil {
+		return err
+	}
+	client, err := newAdminClient(*adminURL)
+	if err != nil {
+		return err
+	}
+	dump, err := client.ConfigDump()
+	if err != nil {
+		return merry.Prepend(err, "failed to read the running config")
+	}
+
+	differences, err := drift.Compare(*generated, dump)
+	if err != nil {
+		return err
+	}
+	if len(differences) == 0 {
+		log.Info("running envoy config matches the generated config")
+		return nil
+	}
+	for _, d := range differences {
+		fmt.Fprintln(os.Stdout, d)
+	}
+	if *ignoreRejected {
+		rejected, err := ioutil.ReadFile(rejectedPath(*configPath))
+		if err == nil {
+			log.WithField("error", strings.TrimSpace(string(rejected))).Warn("running envoy config drifted because the config for the current options was rejected")
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return merry.Wrap(err)
+		}
+	}
+	return merry.Errorf("running envoy config drifted from the generated config: %d differences", len(differences))
+}
+
+// rejectedPath is where `observer run` records why it rejected the config
+// for the current options while it keeps the config at configPath running.
+func rejectedPath(configPath string) string {
+	return configPath + ".rejected"
+}
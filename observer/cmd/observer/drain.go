@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/admin"
+	log "github.com/sirupsen/logrus"
+)
+
+// runDrain gracefully takes the proxy out of service before the pod is
+// stopped. Health checks are failed first so that no new traffic is routed
+// to the pod, then the listeners stop accepting connections and the command
+// waits for the open connections to close.
+func runDrain(args []string) error {
+	flags := flag.NewFlagSet("drain", flag.ContinueOnError)
+	adminURL := adminFlag(flags)
+	delay := flags.Duration("delay", 5*time.Second, "time new connections are still accepted after failing health checks")
+	timeout := flags.Duration("timeout", 20*time.Second, "time open connections get to close once the listeners are drained")
+	interval := flags.Duration("interval", time.Second, "time between connection checks")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := newAdminClient(*adminURL)
+	if err != nil {
+		return err
+	}
+	return drain(client, *delay, *timeout, *interval)
+}
+
+func drain(client *admin.Client, delay, timeout, interval time.Duration) error {
+	if err := client.FailHealthChecks(); err != nil {
+		return merry.Prepend(err, "failed to fail envoy health checks")
+	}
+	log.WithField("delay", delay.String()).Info("failed envoy health checks")
+	// Endpoints are only removed once the failing health checks are
+	// noticed, until then new connections keep arriving
+	time.Sleep(delay)
+
+	if err := client.DrainListeners(); err != nil {
+		return merry.Prepend(err, "failed to drain envoy listeners")
+	}
+	log.Info("drained envoy listeners")
+
+	deadline := time.Now().Add(timeout)
+	for {
+		stats, err := client.Stats()
+		if err != nil {
+			return err
+		}
+		active := stats.ActiveConnections()
+		if active == 0 {
+			log.Info("all connections closed")
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			log.WithField("connections", active).Warn("connections still open after the drain timeout")
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/inject"
+)
+
+// injectFlags registers the flags selecting the injected images.
+func injectFlags(flags *flag.FlagSet) *inject.Config {
+	defaults := inject.DefaultConfig()
+	cfg := &inject.Config{}
+	flags.StringVar(&cfg.ProxyImage, "proxy-image", defaults.ProxyImage, "observer proxy image")
+	flags.StringVar(&cfg.InitImage, "init-image", defaults.InitImage, "observer init image")
+	flags.StringVar(&cfg.ImagePullPolicy, "image-pull-policy", defaults.ImagePullPolicy, "pull policy of the injected containers")
+	return cfg
+}
+
+func runInject(args []string) error {
+	flags := flag.NewFlagSet("inject", flag.ContinueOnError)
+	filename := flags.String("f", "", "manifest to inject, - reads from stdin")
+	cfg := injectFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *filename == "" {
+		return merry.New("a manifest must be provided with -f")
+	}
+
+	var in io.Reader = os.Stdin
+	if *filename != "-" {
+		f, err := os.Open(*filename)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	return inject.Manifests(*cfg, in, os.Stdout)
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/downward"
 	"github.com/omnition/omnition-observer/observer/pkg/envoy"
 	"github.com/omnition/omnition-observer/observer/pkg/options"
 	log "github.com/sirupsen/logrus"
@@ -23,6 +29,11 @@ func init() {
 	viper.BindEnv("tls_ca_cert")
 	viper.BindEnv("tls_cert")
 	viper.BindEnv("tls_key")
+	viper.BindEnv("tls_ca_cert_file")
+	viper.BindEnv("tls_cert_file")
+	viper.BindEnv("tls_key_file")
+
+	viper.BindEnv("options_file")
 
 	viper.SetDefault("ingress_port", 15001)
 	viper.BindEnv("ingress_port")
@@ -34,6 +45,46 @@ func init() {
 	viper.BindEnv("admin_port")
 	viper.SetDefault("admin_log_path", "/dev/null")
 	viper.BindEnv("admin_log_path")
+	viper.SetDefault("admin_address", "127.0.0.1")
+	viper.BindEnv("admin_address")
+
+	viper.SetDefault("metrics_port", 15090)
+	viper.BindEnv("metrics_port")
+
+	viper.SetDefault("service_name", "unknown-service")
+	viper.BindEnv("service_name")
+	viper.BindEnv("service_namespace")
+	viper.BindEnv("service_version")
+
+	viper.BindEnv("stats_sink")
+	viper.BindEnv("stats_sink_address")
+	viper.SetDefault("stats_sink_port", 8125)
+	viper.BindEnv("stats_sink_port")
+	viper.BindEnv("stats_sink_prefix")
+
+	// Pod names are used as hostnames in Kubernetes
+	hostname, _ := os.Hostname()
+	viper.SetDefault("node_id", hostname)
+	viper.BindEnv("node_id")
+	viper.BindEnv("node_region")
+	viper.BindEnv("node_zone")
+	viper.BindEnv("node_sub_zone")
+	viper.SetDefault("node_metadata", []string{})
+	viper.BindEnv("node_metadata")
+
+	// Values exposed by the Kubernetes Downward API. The environment
+	// variables are not prefixed to match the usual pod spec conventions.
+	viper.BindEnv("pod_name", "POD_NAME")
+	viper.BindEnv("pod_namespace", "POD_NAMESPACE")
+	viper.BindEnv("node_name", "NODE_NAME")
+	viper.SetDefault("downward_labels_path", "/etc/podinfo/labels")
+	viper.BindEnv("downward_labels_path")
+	viper.SetDefault("downward_annotations_path", "/etc/podinfo/annotations")
+	viper.BindEnv("downward_annotations_path")
+	viper.SetDefault("downward_tag_labels", []string{})
+	viper.BindEnv("downward_tag_labels")
+	viper.SetDefault("downward_tag_annotations", []string{})
+	viper.BindEnv("downward_tag_annotations")
 
 	viper.SetDefault("tracing_driver", "zipkin")
 	viper.BindEnv("tracing_driver")
@@ -44,17 +95,154 @@ func init() {
 	viper.SetDefault("tracing_port", 9411)
 	viper.BindEnv("tracing_port")
 
-	viper.SetDefault("tracing_tag_headers", []string{})
+	viper.SetDefault("tracing_tag_headers", "")
 	viper.BindEnv("tracing_tag_headers")
 
-	viper.SetDefault("timeout", "15s")
-	viper.BindEnv("timeout")
+	viper.SetDefault("tracing_sampling", 100)
+	viper.BindEnv("tracing_sampling")
+
+	viper.SetDefault("runtime_dir", "")
+	viper.BindEnv("runtime_dir")
+
+	viper.SetDefault("ingress_timeout", "15s")
+	viper.BindEnv("ingress_timeout")
+	viper.SetDefault("egress_timeout", "15s")
+	viper.BindEnv("egress_timeout")
+	viper.SetDefault("ingress_timeout_rules", []string{})
+	viper.BindEnv("ingress_timeout_rules")
+	viper.SetDefault("egress_timeout_rules", []string{})
+	viper.BindEnv("egress_timeout_rules")
 
 	viper.SetDefault("num_trusted_hops", "0")
 	viper.BindEnv("num_trusted_hops")
+
+	viper.SetDefault("egress_retry_on", []string{})
+	viper.BindEnv("egress_retry_on")
+	viper.SetDefault("egress_retry_num_retries", 1)
+	viper.BindEnv("egress_retry_num_retries")
+	viper.BindEnv("egress_retry_per_try_timeout")
+	viper.BindEnv("egress_retry_backoff_base")
+	viper.BindEnv("egress_retry_backoff_max")
+	viper.SetDefault("egress_retry_hosts", []string{})
+	viper.BindEnv("egress_retry_hosts")
+	viper.SetDefault("egress_retry_ports", []string{})
+	viper.BindEnv("egress_retry_ports")
+
+	// Circuit breakers default to Envoy's own thresholds for both directions
+	viper.SetDefault("ingress_max_connections", 1024)
+	viper.BindEnv("ingress_max_connections")
+	viper.SetDefault("ingress_max_pending_requests", 1024)
+	viper.BindEnv("ingress_max_pending_requests")
+	viper.SetDefault("ingress_max_requests", 1024)
+	viper.BindEnv("ingress_max_requests")
+	viper.SetDefault("ingress_max_retries", 3)
+	viper.BindEnv("ingress_max_retries")
+
+	viper.SetDefault("egress_max_connections", 1024)
+	viper.BindEnv("egress_max_connections")
+	viper.SetDefault("egress_max_pending_requests", 1024)
+	viper.BindEnv("egress_max_pending_requests")
+	viper.SetDefault("egress_max_requests", 1024)
+	viper.BindEnv("egress_max_requests")
+	viper.SetDefault("egress_max_retries", 3)
+	viper.BindEnv("egress_max_retries")
+
+	// Ingress traffic only ever reaches the local application so outlier
+	// detection is disabled there by default
+	viper.SetDefault("ingress_outlier_consecutive_5xx", 0)
+	viper.BindEnv("ingress_outlier_consecutive_5xx")
+	viper.SetDefault("ingress_outlier_interval", "10s")
+	viper.BindEnv("ingress_outlier_interval")
+	viper.SetDefault("ingress_outlier_base_ejection_time", "30s")
+	viper.BindEnv("ingress_outlier_base_ejection_time")
+	viper.SetDefault("ingress_outlier_max_ejection_percent", 10)
+	viper.BindEnv("ingress_outlier_max_ejection_percent")
+
+	viper.SetDefault("egress_outlier_consecutive_5xx", 5)
+	viper.BindEnv("egress_outlier_consecutive_5xx")
+	viper.SetDefault("egress_outlier_interval", "10s")
+	viper.BindEnv("egress_outlier_interval")
+	viper.SetDefault("egress_outlier_base_ejection_time", "30s")
+	viper.BindEnv("egress_outlier_base_ejection_time")
+	viper.SetDefault("egress_outlier_max_ejection_percent", 10)
+	viper.BindEnv("egress_outlier_max_ejection_percent")
+
+	viper.SetDefault("cluster_connect_timeout", "0.5s")
+	viper.BindEnv("cluster_connect_timeout")
+	viper.BindEnv("cluster_idle_timeout")
+	viper.BindEnv("cluster_keepalive_probes")
+	viper.BindEnv("cluster_keepalive_time")
+	viper.BindEnv("cluster_keepalive_interval")
+	viper.BindEnv("cluster_cleanup_interval")
+
+	viper.SetDefault("http2_max_concurrent_streams", 2147483647)
+	viper.BindEnv("http2_max_concurrent_streams")
+	viper.BindEnv("http2_initial_stream_window_size")
+	viper.BindEnv("http2_initial_connection_window_size")
+	viper.SetDefault("http2_upstream_protocol", "http2")
+	viper.BindEnv("http2_upstream_protocol")
+	viper.SetDefault("upstream_protocol_ports", []string{})
+	viper.BindEnv("upstream_protocol_ports")
+
+	viper.SetDefault("http1_accept_http_10", false)
+	viper.BindEnv("http1_accept_http_10")
+	viper.BindEnv("http1_default_host_for_http_10")
+	viper.SetDefault("http1_allow_absolute_url", false)
+	viper.BindEnv("http1_allow_absolute_url")
+	viper.SetDefault("http1_header_key_format", "default")
+	viper.BindEnv("http1_header_key_format")
+
+	viper.SetDefault("drain_type", "default")
+	viper.BindEnv("drain_type")
+	viper.SetDefault("drain_timeout", "5s")
+	viper.BindEnv("drain_timeout")
+
+	viper.BindEnv("access_log_path")
+	viper.SetDefault("access_log_format", "text")
+	viper.BindEnv("access_log_format")
+	viper.SetDefault("access_log_filter", "all")
+	viper.BindEnv("access_log_filter")
+	viper.SetDefault("access_log_sample_percent", 100)
+	viper.BindEnv("access_log_sample_percent")
+}
+
+// Subcommands of the observer binary. Without a subcommand the generated
+// Envoy config is printed.
+var commands = map[string]func(args []string) error{
+	"diff":       runDiff,
+	"drain":      runDrain,
+	"inject":     runInject,
+	"run":        runSupervisor,
+	"runtime":    runRuntime,
+	"status":     runStatus,
+	"version":    runVersion,
+	"wait-ready": runWaitReady,
+	"webhook":    runWebhook,
+}
+
+// exitStatus is returned by commands that exit with the status of a child
+// process once they have cleaned up.
+type exitStatus int
+
+func (status exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(status))
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalf("unknown command [%s]", os.Args[1])
+		}
+		if err := command(os.Args[2:]); err != nil {
+			if status, ok := err.(exitStatus); ok {
+				os.Exit(int(status))
+			}
+			log.Fatal(err)
+		}
+		return
+	}
+
 	serialized, err := run()
 	if err != nil {
 		log.Fatal(err)
@@ -83,26 +271,365 @@ func run() ([]byte, error) {
 }
 
 func buildOptions() (options.Options, error) {
-	return options.New(
-		viper.GetInt("ingress_port"),
-		viper.GetInt("egress_port"),
+	resetOverrides()
+	if err := loadOptionsFile(viper.GetString("options_file")); err != nil {
+		return options.Options{}, err
+	}
+	if err := migrateSettings(); err != nil {
+		return options.Options{}, err
+	}
+
+	tlsCACert, err := getFileSetting("tls_ca_cert")
+	if err != nil {
+		return options.Options{}, err
+	}
+	tlsCert, err := getFileSetting("tls_cert")
+	if err != nil {
+		return options.Options{}, err
+	}
+	tlsKey, err := getFileSetting("tls_key")
+	if err != nil {
+		return options.Options{}, err
+	}
 
-		viper.GetString("tracing_driver"),
-		viper.GetString("tracing_host"),
-		viper.GetInt("tracing_port"),
-		viper.GetStringSlice("tracing_tag_headers"),
+	podInfo, err := downward.Load(
+		viper.GetString("pod_name"),
+		viper.GetString("pod_namespace"),
+		viper.GetString("node_name"),
+		viper.GetString("downward_labels_path"),
+		viper.GetString("downward_annotations_path"),
+	)
+	if err != nil {
+		return options.Options{}, err
+	}
+	if err := applyAnnotations(podInfo.Annotations); err != nil {
+		return options.Options{}, err
+	}
+
+	retryPorts, err := getIntSlice("egress_retry_ports")
+	if err != nil {
+		return options.Options{}, err
+	}
+
+	ingressTimeoutRules, err := options.ParseTimeoutRules(viper.GetStringSlice("ingress_timeout_rules"))
+	if err != nil {
+		return options.Options{}, err
+	}
+	egressTimeoutRules, err := options.ParseTimeoutRules(viper.GetStringSlice("egress_timeout_rules"))
+	if err != nil {
+		return options.Options{}, err
+	}
 
-		viper.GetBool("tls_enabled"),
-		viper.GetString("tls_ca_cert"),
-		viper.GetString("tls_cert"),
-		viper.GetString("tls_key"),
+	upstreamProtocols, err := options.ParsePortUpstreamProtocols(viper.GetStringSlice("upstream_protocol_ports"))
+	if err != nil {
+		return options.Options{}, err
+	}
 
-		viper.GetInt("admin_port"),
-		viper.GetString("admin_log_path"),
+	nodeMetadata, err := options.ParseKeyValues(viper.GetStringSlice("node_metadata"))
+	if err != nil {
+		return options.Options{}, err
+	}
 
-		viper.GetDuration("timeout"),
-		viper.GetInt("num_trusted_hops"),
+	podTags := podInfo.Tags(
+		viper.GetStringSlice("downward_tag_labels"),
+		viper.GetStringSlice("downward_tag_annotations"),
 	)
+	// Explicitly configured metadata takes precedence over pod information
+	for k, v := range podTags {
+		if _, ok := nodeMetadata[k]; !ok {
+			nodeMetadata[k] = v
+		}
+	}
+
+	return options.New(options.Options{
+		IngressPort: viper.GetInt("ingress_port"),
+		EgressPort:  viper.GetInt("egress_port"),
+
+		TracingDriver:     viper.GetString("tracing_driver"),
+		TracingHost:       viper.GetString("tracing_host"),
+		TracingPort:       viper.GetInt("tracing_port"),
+		TracingTagHeaders: options.ParseList(viper.GetString("tracing_tag_headers")),
+		TracingTags:       podTags,
+		TracingSampling:   viper.GetFloat64("tracing_sampling"),
+
+		RuntimeDir: viper.GetString("runtime_dir"),
+
+		TLSEnabled: viper.GetBool("tls_enabled"),
+		TLSCACert:  tlsCACert,
+		TLSCert:    tlsCert,
+		TLSKey:     tlsKey,
+
+		AdminPort:    viper.GetInt("admin_port"),
+		AdminLogPath: viper.GetString("admin_log_path"),
+
+		TimeoutDuration:       viper.GetDuration("ingress_timeout"),
+		EgressTimeoutDuration: viper.GetDuration("egress_timeout"),
+		IngressTimeoutRules:   ingressTimeoutRules,
+		EgressTimeoutRules:    egressTimeoutRules,
+		TrustedHopsCount:      viper.GetInt("num_trusted_hops"),
+
+		EgressRetryPolicy: options.RetryPolicy{
+			RetryOn:       viper.GetStringSlice("egress_retry_on"),
+			NumRetries:    viper.GetInt("egress_retry_num_retries"),
+			PerTryTimeout: viper.GetDuration("egress_retry_per_try_timeout"),
+			BackOffBase:   viper.GetDuration("egress_retry_backoff_base"),
+			BackOffMax:    viper.GetDuration("egress_retry_backoff_max"),
+			Hosts:         viper.GetStringSlice("egress_retry_hosts"),
+			Ports:         retryPorts,
+		},
+
+		IngressCircuitBreakers:  getCircuitBreakers("ingress"),
+		EgressCircuitBreakers:   getCircuitBreakers("egress"),
+		IngressOutlierDetection: getOutlierDetection("ingress"),
+		EgressOutlierDetection:  getOutlierDetection("egress"),
+
+		ClusterConnection: options.ConnectionSettings{
+			ConnectTimeout:    viper.GetDuration("cluster_connect_timeout"),
+			IdleTimeout:       viper.GetDuration("cluster_idle_timeout"),
+			KeepaliveProbes:   viper.GetInt("cluster_keepalive_probes"),
+			KeepaliveTime:     viper.GetDuration("cluster_keepalive_time"),
+			KeepaliveInterval: viper.GetDuration("cluster_keepalive_interval"),
+			CleanupInterval:   viper.GetDuration("cluster_cleanup_interval"),
+		},
+
+		HTTP2: options.HTTP2Settings{
+			MaxConcurrentStreams:        viper.GetInt("http2_max_concurrent_streams"),
+			InitialStreamWindowSize:     viper.GetInt("http2_initial_stream_window_size"),
+			InitialConnectionWindowSize: viper.GetInt("http2_initial_connection_window_size"),
+			UpstreamProtocol:            viper.GetString("http2_upstream_protocol"),
+			PortUpstreamProtocols:       upstreamProtocols,
+		},
+
+		HTTP1: options.HTTP1Settings{
+			AcceptHTTP10:         viper.GetBool("http1_accept_http_10"),
+			DefaultHostForHTTP10: viper.GetString("http1_default_host_for_http_10"),
+			AllowAbsoluteURL:     viper.GetBool("http1_allow_absolute_url"),
+			HeaderKeyFormat:      viper.GetString("http1_header_key_format"),
+		},
+
+		AccessLog: options.AccessLog{
+			Path:          viper.GetString("access_log_path"),
+			Format:        viper.GetString("access_log_format"),
+			Filter:        viper.GetString("access_log_filter"),
+			SamplePercent: viper.GetInt("access_log_sample_percent"),
+		},
+
+		Drain: options.DrainSettings{
+			Type:    viper.GetString("drain_type"),
+			Timeout: viper.GetDuration("drain_timeout"),
+		},
+
+		AdminAddress: viper.GetString("admin_address"),
+		MetricsPort:  viper.GetInt("metrics_port"),
+
+		ServiceName:      viper.GetString("service_name"),
+		ServiceNamespace: viper.GetString("service_namespace"),
+		ServiceVersion:   viper.GetString("service_version"),
+		StatsSink: options.StatsSink{
+			Type:    viper.GetString("stats_sink"),
+			Address: viper.GetString("stats_sink_address"),
+			Port:    viper.GetInt("stats_sink_port"),
+			Prefix:  viper.GetString("stats_sink_prefix"),
+		},
+
+		Node: options.Node{
+			ID:       viper.GetString("node_id"),
+			Region:   viper.GetString("node_region"),
+			Zone:     viper.GetString("node_zone"),
+			SubZone:  viper.GetString("node_sub_zone"),
+			Metadata: nodeMetadata,
+		},
+	})
+}
+
+// loadOptionsFile reads options from a YAML file. Keys are the names of the
+// environment variables without the OBS_ prefix, in lower case. Environment
+// variables take precedence over the file. Deprecated keys are migrated like
+// deprecated environment variables.
+func loadOptionsFile(path string) error {
+	content := []byte{}
+	if path != "" {
+		var err error
+		if content, err = ioutil.ReadFile(path); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+	content, err := migrateOptionsFile(content)
+	if err != nil {
+		return err
+	}
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(bytes.NewReader(content)); err != nil {
+		return merry.Prepend(err, "invalid options file")
+	}
+	return nil
+}
+
+// migrateOptionsFile returns the options file content with deprecated keys
+// rewritten to their current names and values, warning about each of them.
+func migrateOptionsFile(content []byte) ([]byte, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, merry.Prepend(err, "invalid options file")
+	}
+	keys := map[string]string{}
+	settings := map[string]string{}
+	for key, value := range values {
+		switch value.(type) {
+		case map[interface{}]interface{}, []interface{}, nil:
+			continue
+		}
+		setting := strings.ToUpper(key)
+		keys[setting] = key
+		settings[setting] = fmt.Sprint(value)
+	}
+
+	migrations, err := options.Migrate(settings)
+	if err != nil {
+		return nil, merry.Prepend(err, "invalid options file")
+	}
+	if len(migrations) == 0 {
+		return content, nil
+	}
+	for _, m := range migrations {
+		delete(values, keys[m.Setting])
+		if !m.Ignored {
+			delete(values, keys[m.Replacement])
+			values[strings.ToLower(m.Replacement)] = m.NewValue
+		}
+		m.Setting = strings.ToLower(m.Setting)
+		m.Replacement = strings.ToLower(m.Replacement)
+		m.Warn()
+	}
+	migrated, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return migrated, nil
+}
+
+// getFileSetting returns the content of the file set with <key>_file, or the
+// value of key when no file is set.
+func getFileSetting(key string) (string, error) {
+	path := viper.GetString(key + "_file")
+	if path == "" {
+		return viper.GetString(key), nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", merry.Wrap(err)
+	}
+	return string(content), nil
+}
+
+// watchedFiles returns the files options are read from.
+func watchedFiles() []string {
+	files := []string{}
+	for _, key := range []string{
+		"options_file",
+		"tls_ca_cert_file",
+		"tls_cert_file",
+		"tls_key_file",
+		"downward_labels_path",
+		"downward_annotations_path",
+	} {
+		if path := viper.GetString(key); path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// Keys set with viper.Set by migrations and annotations. They are cleared
+// before options are built so that only the current sources apply.
+var overrides = map[string]bool{}
+
+func override(key string, value interface{}) {
+	viper.Set(key, value)
+	overrides[key] = true
+}
+
+func resetOverrides() {
+	for key := range overrides {
+		viper.Set(key, nil)
+	}
+	overrides = map[string]bool{}
+}
+
+// migrateSettings rewrites deprecated settings from the environment to their
+// current names and values, warning about each of them.
+func migrateSettings() error {
+	settings := map[string]string{}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "OBS_") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(kv, "OBS_"), "=", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			settings[parts[0]] = parts[1]
+		}
+	}
+
+	migrations, err := options.Migrate(settings)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		m.Warn()
+		if !m.Ignored {
+			override(strings.ToLower(m.Replacement), m.NewValue)
+		}
+	}
+	return nil
+}
+
+// applyAnnotations configures the observer from the pod's observer
+// annotations. Options set through environment variables take precedence.
+func applyAnnotations(annotations map[string]string) error {
+	values, err := options.ParseAnnotations(annotations)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		key := strings.ToLower(v.Env)
+		if v.Init || os.Getenv("OBS_"+v.Env) != "" || overrides[key] {
+			continue
+		}
+		override(key, v.Value)
+	}
+	return nil
+}
+
+func getCircuitBreakers(direction string) options.CircuitBreakers {
+	return options.CircuitBreakers{
+		MaxConnections:     viper.GetInt(direction + "_max_connections"),
+		MaxPendingRequests: viper.GetInt(direction + "_max_pending_requests"),
+		MaxRequests:        viper.GetInt(direction + "_max_requests"),
+		MaxRetries:         viper.GetInt(direction + "_max_retries"),
+	}
+}
+
+func getOutlierDetection(direction string) options.OutlierDetection {
+	return options.OutlierDetection{
+		Consecutive5xx:     viper.GetInt(direction + "_outlier_consecutive_5xx"),
+		Interval:           viper.GetDuration(direction + "_outlier_interval"),
+		BaseEjectionTime:   viper.GetDuration(direction + "_outlier_base_ejection_time"),
+		MaxEjectionPercent: viper.GetInt(direction + "_outlier_max_ejection_percent"),
+	}
+}
+
+// getIntSlice reads a space separated list of integers
+func getIntSlice(key string) ([]int, error) {
+	values := []int{}
+	for _, v := range viper.GetStringSlice(key) {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, merry.Errorf("invalid value [%s] for %s: expected a list of integers", v, key)
+		}
+		values = append(values, i)
+	}
+	return values, nil
 }
 
 func generateConfig(options *options.Options) (*envoy.Config, error) {
@@ -1,12 +1,28 @@
 package main
 
 import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ansel1/merry"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/omnition/omnition-observer/observer/pkg/admin"
 	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+	"github.com/omnition/omnition-observer/observer/pkg/reload"
+	"github.com/omnition/omnition-observer/observer/pkg/xds"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v2"
 )
@@ -17,7 +33,7 @@ func TestCMDBasic(t *testing.T) {
 	c, err := unmarshalConfig(config)
 	assert.Nil(t, err)
 
-	assert.Equal(t, len(c.StaticResources.Listeners), 2)
+	assert.Equal(t, len(c.StaticResources.Listeners), 3)
 
 	ingress := c.StaticResources.Listeners[0]
 	egress := c.StaticResources.Listeners[1]
@@ -31,7 +47,7 @@ func TestCMDBasic(t *testing.T) {
 	assert.Equal(t, egress.Name, "egress_listener")
 	assert.Equal(t, egress.Address.SocketAddress.PortValue, 15002)
 	assert.Equal(t, len(egress.FilterChains), 3)
-	var nilSlice []string
+	var nilSlice []envoy.CustomTag
 	assert.Equal(t, egress.FilterChains[0].Filters[0].TypedConfig.Tracing.CustomTags, nilSlice)
 	assert.Equal(t, egress.FilterChains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster, "h1_egress_cluster")
 	assert.Equal(t, egress.FilterChains[1].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster, "h2_egress_cluster")
@@ -43,6 +59,7 @@ func TestCMDBasic(t *testing.T) {
 	assert.Equal(t, c.StaticResources.Clusters[4].Name, "tcp_ingress_cluster")
 	assert.Equal(t, c.StaticResources.Clusters[5].Name, "tcp_egress_cluster")
 	assert.Equal(t, c.StaticResources.Clusters[6].Name, "tracing_zipkin_cluster")
+	assert.Equal(t, c.StaticResources.Clusters[7].Name, "admin_cluster")
 }
 
 func TestCMDWithOptions(t *testing.T) {
@@ -79,7 +96,10 @@ func TestCMDWithOptions(t *testing.T) {
 		assert.Equal(t, envVariables["OBS_TRACING_PORT"], strconv.Itoa(c.StaticResources.Clusters[6].Hosts[0].SocketAddress.PortValue))
 		h1Chain := c.StaticResources.Listeners[0].FilterChains[0]
 		h2Chain := c.StaticResources.Listeners[0].FilterChains[1]
-		headers := strings.Split(envVariables["OBS_TRACING_TAG_HEADERS"], " ")
+		headers := []envoy.CustomTag{}
+		for _, h := range strings.Split(envVariables["OBS_TRACING_TAG_HEADERS"], " ") {
+			headers = append(headers, envoy.CustomTag{Tag: h, RequestHeader: &envoy.CustomTagHeader{Name: h}})
+		}
 		assert.Equal(t, headers, h1Chain.Filters[0].TypedConfig.Tracing.CustomTags)
 		assert.Equal(t, headers, h2Chain.Filters[0].TypedConfig.Tracing.CustomTags)
 		assert.Equal(t, envVariables["OBS_NUM_TRUSTED_HOPS"], strconv.Itoa(h1Chain.Filters[0].TypedConfig.TrustedHopsCount))
@@ -164,15 +184,2069 @@ func TestCMDWithOptions(t *testing.T) {
 	})
 }
 
-func setEnvironmentVariables(t *testing.T, envVars map[string]string) {
-	for k, v := range envVars {
-		err := os.Setenv(k, v)
+func TestCMDRetryPolicy(t *testing.T) {
+	t.Run("Succeed with global egress retry policy", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_EGRESS_RETRY_ON":              "5xx connect-failure,reset",
+			"OBS_EGRESS_RETRY_NUM_RETRIES":     "3",
+			"OBS_EGRESS_RETRY_PER_TRY_TIMEOUT": "250ms",
+			"OBS_EGRESS_RETRY_BACKOFF_BASE":    "25ms",
+			"OBS_EGRESS_RETRY_BACKOFF_MAX":     "1s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
 		assert.Nil(t, err)
-	}
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		assert.Contains(t, string(config), "per_try_timeout: 0.25s")
+		for _, chain := range c.StaticResources.Listeners[1].FilterChains[:2] {
+			vhosts := chain.Filters[0].TypedConfig.RouteConfig.VirtualHosts
+			assert.Equal(t, 1, len(vhosts))
+			policy := vhosts[0].RetryPolicy
+			assert.NotNil(t, policy)
+			assert.Equal(t, "5xx,connect-failure,reset", policy.RetryOn)
+			assert.Equal(t, 3, policy.NumRetries)
+			assert.Equal(t, envoy.Duration(250*time.Millisecond), policy.PerTryTimeout)
+			assert.Equal(t, envoy.Duration(25*time.Millisecond), policy.RetryBackOff.BaseInterval)
+			assert.Equal(t, envoy.Duration(time.Second), policy.RetryBackOff.MaxInterval)
+		}
+		for _, chain := range c.StaticResources.Listeners[0].FilterChains[:2] {
+			assert.Nil(t, chain.Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].RetryPolicy)
+		}
+	})
+
+	t.Run("Succeed with scoped egress retry policy", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_EGRESS_RETRY_ON":    "5xx",
+			"OBS_EGRESS_RETRY_PORTS": "8080 9090",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		vhosts := c.StaticResources.Listeners[1].FilterChains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts
+		assert.Equal(t, 2, len(vhosts))
+		assert.Nil(t, vhosts[0].RetryPolicy)
+		assert.Equal(t, []string{"*"}, vhosts[0].Domains)
+		assert.Equal(t, "h1_egress_retry_vhost", vhosts[1].Name)
+		assert.Equal(t, []string{"*:8080", "*:9090"}, vhosts[1].Domains)
+		assert.Equal(t, "5xx", vhosts[1].RetryPolicy.RetryOn)
+		assert.Equal(t, 1, vhosts[1].RetryPolicy.NumRetries)
+		assert.Nil(t, vhosts[1].RetryPolicy.RetryBackOff)
+		assert.Equal(t, "h1_egress_cluster", vhosts[1].Routes[0].Route.Cluster)
+	})
+
+	t.Run("Failing: invalid retry condition", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_EGRESS_RETRY_ON": "5xx sometimes",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	t.Run("Failing: invalid retry port", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_EGRESS_RETRY_ON":    "5xx",
+			"OBS_EGRESS_RETRY_PORTS": "http",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
 }
 
-func unmarshalConfig(serializedConfig []byte) (envoy.Config, error) {
-	c := envoy.Config{}
-	err := yaml.Unmarshal(serializedConfig, &c)
-	return c, err
+func TestCMDTimeouts(t *testing.T) {
+	t.Run("Succeed with egress timeout and timeout rules", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_INGRESS_TIMEOUT":      "1m",
+			"OBS_EGRESS_TIMEOUT":       "3s",
+			"OBS_EGRESS_TIMEOUT_RULES": "prefix=/reports,timeout=90s host=billing,port=8080,timeout=0.5s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		assert.Contains(t, string(config), "timeout: 60s")
+		ingressRoutes := c.StaticResources.Listeners[0].FilterChains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes
+		assert.Equal(t, 1, len(ingressRoutes))
+		assert.Equal(t, envoy.Duration(time.Minute), *ingressRoutes[0].Route.Timeout)
+
+		egressRoutes := c.StaticResources.Listeners[1].FilterChains[1].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes
+		assert.Equal(t, 3, len(egressRoutes))
+		assert.Equal(t, "/reports", egressRoutes[0].Match.Prefix)
+		assert.Empty(t, egressRoutes[0].Match.Headers)
+		assert.Equal(t, envoy.Duration(90*time.Second), *egressRoutes[0].Route.Timeout)
+		assert.Equal(t, "/", egressRoutes[1].Match.Prefix)
+		assert.Equal(t, ":authority", egressRoutes[1].Match.Headers[0].Name)
+		assert.Equal(t, "billing:8080", egressRoutes[1].Match.Headers[0].ExactMatch)
+		assert.Equal(t, envoy.Duration(500*time.Millisecond), *egressRoutes[1].Route.Timeout)
+		assert.Equal(t, "h2_egress_cluster", egressRoutes[1].Route.Cluster)
+		assert.Equal(t, "/", egressRoutes[2].Match.Prefix)
+		assert.Empty(t, egressRoutes[2].Match.Headers)
+		assert.Equal(t, envoy.Duration(3*time.Second), *egressRoutes[2].Route.Timeout)
+	})
+
+	t.Run("Failing: timeout rule without match", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_INGRESS_TIMEOUT_RULES": "timeout=5s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	t.Run("Failing: timeout rule without timeout", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_EGRESS_TIMEOUT_RULES": "prefix=/slow",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDCircuitBreakers(t *testing.T) {
+	t.Run("Succeed with default thresholds", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		for _, cluster := range c.StaticResources.Clusters[:6] {
+			assert.Equal(t, 1024, cluster.CircuitBreakers.Thresholds[0].MaxConnections)
+			assert.Equal(t, 3, cluster.CircuitBreakers.Thresholds[0].MaxRetries)
+		}
+		assert.Nil(t, c.StaticResources.Clusters[0].OutlierDetection)
+		assert.Equal(t, 5, c.StaticResources.Clusters[1].OutlierDetection.Consecutive5xx)
+		assert.Nil(t, c.StaticResources.Clusters[6].CircuitBreakers)
+	})
+
+	t.Run("Succeed with custom thresholds", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_EGRESS_MAX_CONNECTIONS":              "10",
+			"OBS_EGRESS_MAX_PENDING_REQUESTS":         "20",
+			"OBS_EGRESS_MAX_REQUESTS":                 "30",
+			"OBS_EGRESS_MAX_RETRIES":                  "0",
+			"OBS_INGRESS_OUTLIER_CONSECUTIVE_5XX":     "7",
+			"OBS_EGRESS_OUTLIER_INTERVAL":             "2s",
+			"OBS_EGRESS_OUTLIER_MAX_EJECTION_PERCENT": "50",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		egressTCP := c.StaticResources.Clusters[5]
+		assert.Equal(t, "tcp_egress_cluster", egressTCP.Name)
+		assert.Equal(t, envoy.CircuitBreakerThresholds{
+			Priority:           "DEFAULT",
+			MaxConnections:     10,
+			MaxPendingRequests: 20,
+			MaxRequests:        30,
+			MaxRetries:         0,
+		}, egressTCP.CircuitBreakers.Thresholds[0])
+		assert.Equal(t, envoy.Duration(2*time.Second), egressTCP.OutlierDetection.Interval)
+		assert.Equal(t, 50, egressTCP.OutlierDetection.MaxEjectionPercent)
+
+		ingressH1 := c.StaticResources.Clusters[0]
+		assert.Equal(t, 1024, ingressH1.CircuitBreakers.Thresholds[0].MaxConnections)
+		assert.Equal(t, 7, ingressH1.OutlierDetection.Consecutive5xx)
+		assert.Equal(t, envoy.Duration(30*time.Second), ingressH1.OutlierDetection.BaseEjectionTime)
+	})
+
+	t.Run("Failing: zero max connections", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_INGRESS_MAX_CONNECTIONS": "0",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDClusterConnection(t *testing.T) {
+	t.Run("Succeed with default connection settings", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		assert.Contains(t, string(config), "connect_timeout: 0.5s")
+		for _, cluster := range c.StaticResources.Clusters[:6] {
+			assert.Equal(t, envoy.Duration(500*time.Millisecond), cluster.ConnectTimeout)
+			assert.Nil(t, cluster.CommonHTTPProtocolOptions)
+			assert.Nil(t, cluster.UpstreamConnectionOptions)
+			assert.Equal(t, envoy.Duration(0), cluster.CleanupInterval)
+		}
+		assert.Equal(t, envoy.Duration(500*time.Millisecond), c.StaticResources.Clusters[6].ConnectTimeout, "The tracing cluster should use the connect timeout")
+	})
+
+	t.Run("Succeed with custom connection settings", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_CLUSTER_CONNECT_TIMEOUT":    "2s",
+			"OBS_CLUSTER_IDLE_TIMEOUT":       "5m",
+			"OBS_CLUSTER_KEEPALIVE_PROBES":   "3",
+			"OBS_CLUSTER_KEEPALIVE_TIME":     "60s",
+			"OBS_CLUSTER_KEEPALIVE_INTERVAL": "10s",
+			"OBS_CLUSTER_CLEANUP_INTERVAL":   "30s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		for _, cluster := range c.StaticResources.Clusters[:6] {
+			assert.Equal(t, envoy.Duration(2*time.Second), cluster.ConnectTimeout)
+			assert.Equal(t, envoy.Duration(30*time.Second), cluster.CleanupInterval)
+			assert.Equal(t, envoy.TCPKeepalive{
+				KeepaliveProbes:   3,
+				KeepaliveTime:     60,
+				KeepaliveInterval: 10,
+			}, cluster.UpstreamConnectionOptions.TCPKeepalive)
+		}
+		assert.Equal(t, envoy.Duration(5*time.Minute), c.StaticResources.Clusters[0].CommonHTTPProtocolOptions.IdleTimeout)
+		assert.Nil(t, c.StaticResources.Clusters[4].CommonHTTPProtocolOptions)
+		assert.Equal(t, envoy.Duration(2*time.Second), c.StaticResources.Clusters[6].ConnectTimeout)
+
+		tcpChain := c.StaticResources.Listeners[1].FilterChains[2]
+		assert.Equal(t, envoy.Duration(5*time.Minute), *tcpChain.Filters[0].TypedConfig.IdleTimeout)
+	})
+
+	t.Run("Failing: fractional keepalive time", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_CLUSTER_KEEPALIVE_TIME": "1500ms",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	t.Run("Failing: zero connect timeout", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_CLUSTER_CONNECT_TIMEOUT": "0s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDHTTP2(t *testing.T) {
+	t.Run("Succeed with HTTP/2 tuning", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_HTTP2_MAX_CONCURRENT_STREAMS":         "100",
+			"OBS_HTTP2_INITIAL_STREAM_WINDOW_SIZE":     "65536",
+			"OBS_HTTP2_INITIAL_CONNECTION_WINDOW_SIZE": "1048576",
+			"OBS_HTTP2_UPSTREAM_PROTOCOL":              "downstream",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		for _, cluster := range c.StaticResources.Clusters[2:4] {
+			assert.Equal(t, envoy.HTTP2ProtocolOptions{
+				MaxConcurrentStreams:        100,
+				InitialStreamWindowSize:     65536,
+				InitialConnectionWindowSize: 1048576,
+			}, cluster.HTTP2ProtocolOptions)
+			assert.Empty(t, cluster.ProtocolSelection, "HTTP/2 clusters should always use HTTP/2")
+		}
+		assert.Equal(t, "downstream_ingress_cluster", c.StaticResources.Clusters[6].Name)
+		assert.Equal(t, "downstream_egress_cluster", c.StaticResources.Clusters[7].Name)
+		for _, cluster := range c.StaticResources.Clusters[6:8] {
+			assert.Equal(t, "USE_DOWNSTREAM_PROTOCOL", cluster.ProtocolSelection)
+			assert.Equal(t, 100, cluster.HTTP2ProtocolOptions.MaxConcurrentStreams)
+		}
+		assert.Empty(t, c.StaticResources.Clusters[0].ProtocolSelection)
+
+		chains := c.StaticResources.Listeners[1].FilterChains
+		assert.Equal(t, "downstream_egress_cluster", chains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+		assert.Equal(t, "downstream_egress_cluster", chains[1].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+		assert.Equal(t, "tcp_egress_cluster", chains[2].Filters[0].TypedConfig.Cluster)
+	})
+
+	t.Run("Succeed with per port upstream protocols and downstream protocol", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_HTTP2_UPSTREAM_PROTOCOL": "downstream",
+			"OBS_UPSTREAM_PROTOCOL_PORTS": "9090=http2 8080=http1",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		chains := c.StaticResources.Listeners[1].FilterChains
+		assert.Equal(t, 9, len(chains))
+		assert.Equal(t, "downstream_egress_cluster", chains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+		assert.Equal(t, "h1_egress_cluster", chains[4].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+		assert.Equal(t, 9090, chains[6].FilterChainMatch.DestinationPort)
+		assert.Equal(t, []string{"http/1.1"}, chains[6].FilterChainMatch.ApplicationProtocols)
+		assert.Equal(t, "h2_egress_cluster", chains[6].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster,
+			"HTTP/1 clients of a port forced to HTTP/2 should not use the downstream protocol")
+		assert.Empty(t, c.StaticResources.Clusters[3].ProtocolSelection)
+	})
+
+	t.Run("Succeed with per port upstream protocols", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_UPSTREAM_PROTOCOL_PORTS": "9090=http2 8080=http1",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		chains := c.StaticResources.Listeners[1].FilterChains
+		assert.Equal(t, 9, len(chains))
+		assert.Equal(t, 0, chains[1].FilterChainMatch.DestinationPort)
+		assert.Equal(t, "h2_egress_cluster", chains[1].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+
+		assert.Equal(t, 8080, chains[3].FilterChainMatch.DestinationPort)
+		assert.Equal(t, []string{"http/1.1"}, chains[3].FilterChainMatch.ApplicationProtocols)
+		assert.Equal(t, "h1_egress_cluster", chains[3].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+		assert.Equal(t, 8080, chains[4].FilterChainMatch.DestinationPort)
+		assert.Equal(t, []string{"h2"}, chains[4].FilterChainMatch.ApplicationProtocols)
+		assert.Equal(t, "h1_egress_cluster", chains[4].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+		assert.Equal(t, 8080, chains[5].FilterChainMatch.DestinationPort)
+		assert.Equal(t, "tcp_egress_cluster", chains[5].Filters[0].TypedConfig.Cluster)
+
+		assert.Equal(t, 9090, chains[6].FilterChainMatch.DestinationPort)
+		assert.Equal(t, "h2_egress_cluster", chains[6].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route.Cluster)
+	})
+
+	t.Run("Failing: invalid upstream protocol", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_UPSTREAM_PROTOCOL_PORTS": "8080=http3",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	t.Run("Failing: window size too small", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_HTTP2_INITIAL_STREAM_WINDOW_SIZE": "1024",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDHTTP1(t *testing.T) {
+	t.Run("Succeed with HTTP/1 codec options", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_HTTP1_ACCEPT_HTTP_10":           "true",
+			"OBS_HTTP1_DEFAULT_HOST_FOR_HTTP_10": "legacy.local",
+			"OBS_HTTP1_ALLOW_ABSOLUTE_URL":       "true",
+			"OBS_HTTP1_HEADER_KEY_FORMAT":        "proper_case",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		assert.Contains(t, string(config), "proper_case_words: {}")
+		for _, listener := range c.StaticResources.Listeners[:2] {
+			h1 := listener.FilterChains[0].Filters[0].TypedConfig.HTTPProtocolOptions
+			assert.True(t, h1.AcceptHTTP10)
+			assert.True(t, h1.AllowAbsoluteURL)
+			assert.Equal(t, "legacy.local", h1.DefaultHostForHTTP10)
+			assert.NotNil(t, h1.HeaderKeyFormat.ProperCaseWords)
+			assert.Nil(t, listener.FilterChains[1].Filters[0].TypedConfig.HTTPProtocolOptions)
+		}
+		assert.NotNil(t, c.StaticResources.Clusters[0].HTTPProtocolOptions.HeaderKeyFormat.ProperCaseWords)
+		assert.Nil(t, c.StaticResources.Clusters[2].HTTPProtocolOptions)
+	})
+
+	t.Run("Succeed without HTTP/1 codec options", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		assert.Nil(t, c.StaticResources.Listeners[0].FilterChains[0].Filters[0].TypedConfig.HTTPProtocolOptions)
+		assert.Nil(t, c.StaticResources.Clusters[0].HTTPProtocolOptions)
+	})
+
+	t.Run("Failing: default host without HTTP/1.0", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_HTTP1_DEFAULT_HOST_FOR_HTTP_10": "legacy.local",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	t.Run("Failing: unknown header key format", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_HTTP1_HEADER_KEY_FORMAT": "upper",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDDrain(t *testing.T) {
+	t.Run("Succeed with drain settings", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_DRAIN_TYPE":    "modify_only",
+			"OBS_DRAIN_TIMEOUT": "30s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		for _, listener := range c.StaticResources.Listeners[:2] {
+			assert.Equal(t, "MODIFY_ONLY", listener.DrainType)
+			hcm := listener.FilterChains[0].Filters[0].TypedConfig
+			if assert.NotNil(t, hcm.DrainTimeout) {
+				assert.Equal(t, 30*time.Second, time.Duration(*hcm.DrainTimeout))
+			}
+			tcp := listener.FilterChains[len(listener.FilterChains)-1].Filters[0].TypedConfig
+			assert.Nil(t, tcp.DrainTimeout, "TCP proxies have no drain timeout")
+		}
+	})
+
+	t.Run("Succeed with default drain settings", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		listener := c.StaticResources.Listeners[0]
+		assert.Equal(t, "", listener.DrainType)
+		assert.Equal(t, 5*time.Second, time.Duration(*listener.FilterChains[0].Filters[0].TypedConfig.DrainTimeout))
+	})
+
+	t.Run("Failing: unknown drain type", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_DRAIN_TYPE": "never",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	drainingAdmin := func(connections []int) (*httptest.Server, *[]string) {
+		requests := []string{}
+		reads := 0
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			if r.URL.Path != "/stats" {
+				w.Write([]byte("OK\n"))
+				return
+			}
+			active := connections[len(connections)-1]
+			if reads < len(connections) {
+				active = connections[reads]
+			}
+			reads++
+			w.Write([]byte(`{"stats": [
+				{"name": "listener.0.0.0.0_15001.downstream_cx_active", "value": ` + strconv.Itoa(active) + `},
+				{"name": "listener.admin.downstream_cx_active", "value": 1}
+			]}`))
+		})), &requests
+	}
+
+	t.Run("Succeed waiting for connections to close", func(t *testing.T) {
+		server, requests := drainingAdmin([]int{2, 1, 0})
+		defer server.Close()
+
+		// When
+		err := runDrain([]string{"-admin-url", server.URL, "-delay", "0", "-interval", "10ms"})
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"POST /healthcheck/fail",
+			"POST /drain_listeners",
+			"GET /stats",
+			"GET /stats",
+			"GET /stats",
+		}, *requests)
+	})
+
+	t.Run("Succeed when connections outlive the timeout", func(t *testing.T) {
+		server, requests := drainingAdmin([]int{2})
+		defer server.Close()
+
+		// When
+		err := runDrain([]string{"-admin-url", server.URL, "-delay", "0", "-timeout", "50ms", "-interval", "10ms"})
+
+		// Then
+		assert.Nil(t, err)
+		assert.True(t, len(*requests) > 3)
+	})
+
+	t.Run("Failing: unreachable admin API", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		// When
+		err := runDrain([]string{"-admin-url", server.URL, "-delay", "0"})
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCMDAccessLog(t *testing.T) {
+	t.Run("Succeed without access logs", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		for _, chain := range c.StaticResources.Listeners[1].FilterChains {
+			assert.Empty(t, chain.Filters[0].TypedConfig.AccessLog)
+		}
+	})
+
+	t.Run("Succeed with JSON access logs on every chain", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_ACCESS_LOG_PATH":   "stdout",
+			"OBS_ACCESS_LOG_FORMAT": "json",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		for _, listener := range c.StaticResources.Listeners[:2] {
+			for _, chain := range listener.FilterChains {
+				logs := chain.Filters[0].TypedConfig.AccessLog
+				assert.Equal(t, 1, len(logs))
+				assert.Equal(t, "/dev/stdout", logs[0].TypedConfig.Path)
+				assert.Nil(t, logs[0].Filter)
+				assert.Equal(t, "%UPSTREAM_HOST%", logs[0].TypedConfig.JSONFormat["upstream_host"])
+			}
+		}
+		egressH1 := c.StaticResources.Listeners[1].FilterChains[0].Filters[0].TypedConfig.AccessLog[0]
+		assert.Equal(t, "egress", egressH1.TypedConfig.JSONFormat["direction"])
+		assert.Equal(t, "%REQ(X-B3-TRACEID)%", egressH1.TypedConfig.JSONFormat["trace_id"])
+		tcp := c.StaticResources.Listeners[1].FilterChains[2].Filters[0].TypedConfig.AccessLog[0]
+		assert.NotContains(t, tcp.TypedConfig.JSONFormat, "response_code")
+	})
+
+	t.Run("Succeed with sampled error access logs", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_ACCESS_LOG_PATH":           "/var/log/omnition/access.log",
+			"OBS_ACCESS_LOG_FILTER":         "errors",
+			"OBS_ACCESS_LOG_SAMPLE_PERCENT": "10",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		h1 := c.StaticResources.Listeners[0].FilterChains[0].Filters[0].TypedConfig.AccessLog[0]
+		assert.Contains(t, h1.TypedConfig.Format, `response_code="%RESPONSE_CODE%"`)
+		filters := h1.Filter.AndFilter.Filters
+		assert.Equal(t, 2, len(filters))
+		assert.Equal(t, 500, filters[0].OrFilter.Filters[0].StatusCodeFilter.Comparison.Value.DefaultValue)
+		assert.NotNil(t, filters[0].OrFilter.Filters[1].ResponseFlagFilter)
+		assert.Equal(t, 10, filters[1].RuntimeFilter.PercentSampled.Numerator)
+
+		tcp := c.StaticResources.Listeners[0].FilterChains[len(c.StaticResources.Listeners[0].FilterChains)-1]
+		tcpFilters := tcp.Filters[0].TypedConfig.AccessLog[0].Filter.AndFilter.Filters
+		assert.NotNil(t, tcpFilters[0].ResponseFlagFilter)
+	})
+
+	t.Run("Failing: invalid access log format", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_ACCESS_LOG_PATH":   "stdout",
+			"OBS_ACCESS_LOG_FORMAT": "xml",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDMetrics(t *testing.T) {
+	t.Run("Succeed with default metrics listener", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_ADMIN_PORT": "9901",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, "127.0.0.1", c.Admin.Address.SocketAddress.Address)
+
+		metrics := c.StaticResources.Listeners[2]
+		assert.Equal(t, "metrics_listener", metrics.Name)
+		assert.Equal(t, 15090, metrics.Address.SocketAddress.PortValue)
+		assert.Empty(t, metrics.Direction)
+		routes := metrics.FilterChains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes
+		assert.Equal(t, 2, len(routes))
+		assert.Equal(t, "/stats/prometheus", routes[0].Match.Path)
+		assert.Empty(t, routes[0].Match.Prefix)
+		assert.Equal(t, "admin_cluster", routes[0].Route.Cluster)
+		assert.Equal(t, "/metrics", routes[1].Match.Path)
+		assert.Equal(t, "/stats/prometheus", routes[1].Route.PrefixRewrite)
+
+		admin := c.StaticResources.Clusters[len(c.StaticResources.Clusters)-1]
+		assert.Equal(t, "admin_cluster", admin.Name)
+		assert.Equal(t, "127.0.0.1", admin.Hosts[0].SocketAddress.Address)
+		assert.Equal(t, 9901, admin.Hosts[0].SocketAddress.PortValue)
+	})
+
+	t.Run("Succeed with metrics listener disabled", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_METRICS_PORT":  "0",
+			"OBS_ADMIN_ADDRESS": "0.0.0.0",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, "0.0.0.0", c.Admin.Address.SocketAddress.Address)
+		assert.Equal(t, 2, len(c.StaticResources.Listeners))
+		for _, cluster := range c.StaticResources.Clusters {
+			assert.NotEqual(t, "admin_cluster", cluster.Name)
+		}
+	})
+
+	t.Run("Failing: metrics port conflicts with admin port", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_METRICS_PORT": "9901",
+			"OBS_ADMIN_PORT":   "9901",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDStats(t *testing.T) {
+	t.Run("Succeed without stats sinks", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		assert.Empty(t, c.StatsSinks)
+		for _, tag := range c.StatsConfig.StatsTags {
+			if tag.TagName == "service" {
+				assert.Equal(t, "unknown-service", tag.FixedValue)
+			}
+		}
+	})
+
+	t.Run("Succeed with dogstatsd sink and service tag", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_SERVICE_NAME":       "checkout",
+			"OBS_STATS_SINK":         "dogstatsd",
+			"OBS_STATS_SINK_ADDRESS": "10.0.0.1",
+			"OBS_STATS_SINK_PREFIX":  "observer",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, 1, len(c.StatsSinks))
+		assert.Equal(t, "envoy.dog_statsd", c.StatsSinks[0].Name)
+		assert.Equal(t, "type.googleapis.com/envoy.config.metrics.v2.DogStatsdSink", c.StatsSinks[0].TypedConfig.ConfigType)
+		assert.Equal(t, "10.0.0.1", c.StatsSinks[0].TypedConfig.Address.SocketAddress.Address)
+		assert.Equal(t, 8125, c.StatsSinks[0].TypedConfig.Address.SocketAddress.PortValue)
+		assert.Equal(t, "observer", c.StatsSinks[0].TypedConfig.Prefix)
+
+		tags := map[string][]envoy.StatsTag{}
+		for _, tag := range c.StatsConfig.StatsTags {
+			tags[tag.TagName] = append(tags[tag.TagName], tag)
+		}
+		assert.Equal(t, "checkout", tags["service"][0].FixedValue)
+		assert.Equal(t, 3, len(tags["direction"]))
+		assert.Equal(t, 3, len(tags["protocol"]))
+	})
+
+	t.Run("Succeed with namespace and version tags", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_SERVICE_NAME":      "checkout",
+			"OBS_SERVICE_NAMESPACE": "shop",
+			"OBS_SERVICE_VERSION":   "1.4.2",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		fixed := map[string]string{}
+		for _, tag := range c.StatsConfig.StatsTags {
+			if tag.FixedValue != "" {
+				fixed[tag.TagName] = tag.FixedValue
+			}
+		}
+		assert.Equal(t, map[string]string{
+			"service":   "checkout",
+			"namespace": "shop",
+			"version":   "1.4.2",
+		}, fixed)
+	})
+
+	t.Run("Succeed extracting direction and protocol tags", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		stats := map[string]map[string]string{
+			"http.h1_ingress.downstream_rq_total":             {"direction": "ingress", "protocol": "h1"},
+			"http.h2_egress.downstream_rq_5xx":                {"direction": "egress", "protocol": "h2"},
+			"tcp.egress_tcp.downstream_cx_total":              {"direction": "egress", "protocol": "tcp"},
+			"cluster.tcp_ingress_cluster.upstream_cx_active":  {"direction": "ingress", "protocol": "tcp"},
+			"cluster.h2_egress_cluster.upstream_rq_pending":   {"direction": "egress", "protocol": "h2"},
+			"cluster.tracing_zipkin_cluster.upstream_rq_time": {},
+		}
+		extractTags(t, c, stats)
+	})
+
+	t.Run("Succeed extracting tags of downstream protocol clusters", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_HTTP2_UPSTREAM_PROTOCOL": "downstream",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		extractTags(t, c, map[string]map[string]string{
+			"cluster.downstream_egress_cluster.upstream_rq_total":   {"direction": "egress", "protocol": "downstream"},
+			"cluster.downstream_ingress_cluster.upstream_cx_active": {"direction": "ingress", "protocol": "downstream"},
+		})
+	})
+
+	t.Run("Failing: stats sink with hostname", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_STATS_SINK":         "statsd",
+			"OBS_STATS_SINK_ADDRESS": "statsd.monitoring",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+// extractTags checks the tags the stats config extracts from stat names.
+func extractTags(t *testing.T, c envoy.Config, stats map[string]map[string]string) {
+	for name, expected := range stats {
+		extracted := map[string]string{}
+		for _, tag := range c.StatsConfig.StatsTags {
+			if tag.Regex == "" {
+				continue
+			}
+			if m := regexp.MustCompile(tag.Regex).FindStringSubmatch(name); m != nil {
+				extracted[tag.TagName] = m[2]
+			}
+		}
+		assert.Equal(t, expected, extracted, name)
+	}
+}
+
+func TestCMDNode(t *testing.T) {
+	t.Run("Succeed with default node", func(t *testing.T) {
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		hostname, _ := os.Hostname()
+		assert.Equal(t, hostname, c.Node.ID)
+		assert.Equal(t, "unknown-service", c.Node.Cluster)
+		assert.Nil(t, c.Node.Locality)
+		assert.Empty(t, c.Node.Metadata)
+	})
+
+	t.Run("Succeed with node identity and metadata", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_SERVICE_NAME":   "checkout",
+			"OBS_NODE_ID":        "checkout-7d9f8-x2x4k",
+			"OBS_NODE_REGION":    "us-east-1",
+			"OBS_NODE_ZONE":      "us-east-1a",
+			"OBS_NODE_METADATA":  "team=payments tier=backend",
+			"OBS_TRACING_DRIVER": "jaeger",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+		assert.Nil(t, err)
+		c, err := generateConfig(&opts)
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, "checkout-7d9f8-x2x4k", c.Node.ID)
+		assert.Equal(t, "checkout", c.Node.Cluster)
+		assert.Equal(t, &envoy.Locality{Region: "us-east-1", Zone: "us-east-1a"}, c.Node.Locality)
+		assert.Equal(t, map[string]string{"team": "payments", "tier": "backend"}, c.Node.Metadata)
+		jaeger := c.Tracing.Http.Config.(envoy.TracingJaegerConfig)
+		assert.Equal(t, "checkout", jaeger.JaegerConfig.ServiceName)
+	})
+
+	t.Run("Failing: zone without region", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_NODE_ZONE": "us-east-1a",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	t.Run("Failing: invalid node metadata", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_NODE_METADATA": "team",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDDownwardAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "podinfo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	labelsPath := filepath.Join(dir, "labels")
+	annotationsPath := filepath.Join(dir, "annotations")
+	err = ioutil.WriteFile(labelsPath, []byte("app=\"checkout\"\nversion=\"1.4.2\"\n"), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(annotationsPath, []byte("team=\"payments \\\"core\\\"\"\n"), 0644)
+	assert.Nil(t, err)
+
+	t.Run("Succeed with pod information", func(t *testing.T) {
+		envVariables := map[string]string{
+			"POD_NAME":                      "checkout-7d9f8-x2x4k",
+			"POD_NAMESPACE":                 "shop",
+			"NODE_NAME":                     "node-1",
+			"OBS_TRACING_TAG_HEADERS":       "x-tenant",
+			"OBS_NODE_METADATA":             "k8s.pod.label.app=override",
+			"OBS_DOWNWARD_LABELS_PATH":      labelsPath,
+			"OBS_DOWNWARD_ANNOTATIONS_PATH": annotationsPath,
+			"OBS_DOWNWARD_TAG_LABELS":       "app version missing",
+			"OBS_DOWNWARD_TAG_ANNOTATIONS":  "team",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		literal := func(tag, value string) envoy.CustomTag {
+			return envoy.CustomTag{Tag: tag, Literal: &envoy.CustomTagLiteral{Value: value}}
+		}
+		assert.Equal(t, []envoy.CustomTag{
+			envoy.CustomTag{Tag: "x-tenant", RequestHeader: &envoy.CustomTagHeader{Name: "x-tenant"}},
+			literal("k8s.namespace.name", "shop"),
+			literal("k8s.node.name", "node-1"),
+			literal("k8s.pod.annotation.team", `payments "core"`),
+			literal("k8s.pod.label.app", "checkout"),
+			literal("k8s.pod.label.version", "1.4.2"),
+			literal("k8s.pod.name", "checkout-7d9f8-x2x4k"),
+		}, c.StaticResources.Listeners[1].FilterChains[0].Filters[0].TypedConfig.Tracing.CustomTags)
+
+		assert.Equal(t, "override", c.Node.Metadata["k8s.pod.label.app"])
+		assert.Equal(t, "shop", c.Node.Metadata["k8s.namespace.name"])
+		assert.Equal(t, "1.4.2", c.Node.Metadata["k8s.pod.label.version"])
+	})
+
+	t.Run("Failing: malformed labels file", func(t *testing.T) {
+		malformedPath := filepath.Join(dir, "malformed")
+		err := ioutil.WriteFile(malformedPath, []byte("app=checkout\n"), 0644)
+		assert.Nil(t, err)
+
+		envVariables := map[string]string{
+			"OBS_DOWNWARD_LABELS_PATH": malformedPath,
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err = buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "podinfo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeAnnotations := func(content string) string {
+		path := filepath.Join(dir, "annotations")
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		assert.Nil(t, err)
+		return path
+	}
+
+	t.Run("Succeed with observer annotations", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_DOWNWARD_ANNOTATIONS_PATH": writeAnnotations(
+				"observer.omnition.io/sampling=\"12.5\"\n" +
+					"observer.omnition.io/service-name=\"checkout\"\n" +
+					"observer.omnition.io/ingress-timeout=\"30s\"\n" +
+					"observer.omnition.io/exclude-inbound-ports=\"22\"\n" +
+					"observer.omnition.io/status=\"injected\"\n" +
+					"prometheus.io/scrape=\"true\"\n",
+			),
+			"OBS_INGRESS_TIMEOUT": "1m",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		config, err := run()
+		assert.Nil(t, err)
+		c, err := unmarshalConfig(config)
+		assert.Nil(t, err)
+
+		// Then
+		tracing := c.StaticResources.Listeners[0].FilterChains[0].Filters[0].TypedConfig.Tracing
+		assert.Equal(t, envoy.Value{Value: 12.5}, tracing.RandomSampling)
+		assert.Equal(t, "checkout", c.Node.Cluster)
+		ingressRoutes := c.StaticResources.Listeners[0].FilterChains[0].Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes
+		assert.Equal(t, envoy.Duration(time.Minute), *ingressRoutes[0].Route.Timeout)
+	})
+
+	t.Run("Succeed with deprecated annotations", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		envVariables := map[string]string{
+			"OBS_DOWNWARD_ANNOTATIONS_PATH": writeAnnotations(
+				"observer.omnition.io/timeout=\"30s\"\n" +
+					"observer.omnition.io/tracing-driver=\"jeager\"\n",
+			),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, 30*time.Second, opts.TimeoutDuration)
+		assert.Equal(t, "jaeger", opts.TracingDriver)
+		warnings := map[string]*logrus.Entry{}
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				warnings[entry.Data["setting"].(string)] = entry
+			}
+		}
+		assert.Len(t, warnings, 2)
+		assert.Equal(t, "observer.omnition.io/ingress-timeout", warnings["observer.omnition.io/timeout"].Data["replacement"])
+		assert.Equal(t, "jaeger", warnings["observer.omnition.io/tracing-driver"].Data["new_value"])
+	})
+
+	t.Run("Succeed without annotations", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_DOWNWARD_ANNOTATIONS_PATH": writeAnnotations(""),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, float64(100), opts.TracingSampling)
+		assert.Equal(t, "unknown-service", opts.ServiceName)
+	})
+
+	t.Run("Failing: invalid annotation value", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_DOWNWARD_ANNOTATIONS_PATH": writeAnnotations("observer.omnition.io/sampling=\"half\"\n"),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+		assert.Contains(t, err.Error(), "observer.omnition.io/sampling")
+	})
+
+	t.Run("Failing: unsupported annotation values", func(t *testing.T) {
+		for _, annotation := range []string{
+			"observer.omnition.io/http2-upstream-protocol=\"http1\"\n",
+			"observer.omnition.io/access-log-filter=\"slow:1s\"\n",
+		} {
+			envVariables := map[string]string{
+				"OBS_DOWNWARD_ANNOTATIONS_PATH": writeAnnotations(annotation),
+			}
+			setEnvironmentVariables(t, envVariables)
+
+			// When
+			_, err := buildOptions()
+			unsetEnvironmentVariables(t, envVariables)
+
+			// Then
+			assert.NotNil(t, err, "Options instantiation should fail for %s", annotation)
+		}
+	})
+
+	t.Run("Failing: unknown annotation", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_DOWNWARD_ANNOTATIONS_PATH": writeAnnotations("observer.omnition.io/samplng=\"10\"\n"),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+		assert.Contains(t, err.Error(), "observer.omnition.io/samplng")
+	})
+}
+
+func TestCMDMigrations(t *testing.T) {
+	t.Run("Succeed with deprecated settings", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		envVariables := map[string]string{
+			"OBS_TIMEOUT":             "1m",
+			"OBS_TRACING_DRIVER":      "jeager",
+			"OBS_TRACING_TAG_HEADERS": "x-tenant  region",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, options.SchemaVersion, opts.SchemaVersion)
+		assert.Equal(t, time.Minute, opts.TimeoutDuration)
+		assert.Equal(t, "jaeger", opts.TracingDriver)
+		assert.Equal(t, []string{"x-tenant", "region"}, opts.TracingTagHeaders)
+
+		warnings := map[string]*logrus.Entry{}
+		for _, entry := range hook.AllEntries() {
+			assert.Equal(t, logrus.WarnLevel, entry.Level)
+			warnings[entry.Data["setting"].(string)] = entry
+		}
+		assert.Len(t, warnings, 3)
+		assert.Equal(t, "INGRESS_TIMEOUT", warnings["TIMEOUT"].Data["replacement"])
+		assert.Equal(t, "jaeger", warnings["TRACING_DRIVER"].Data["new_value"])
+		assert.Equal(t, "x-tenant,region", warnings["TRACING_TAG_HEADERS"].Data["new_value"])
+	})
+
+	t.Run("Succeed with current settings", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		envVariables := map[string]string{
+			"OBS_INGRESS_TIMEOUT":     "5s",
+			"OBS_TRACING_DRIVER":      "jaeger",
+			"OBS_TRACING_TAG_HEADERS": "x-tenant,region",
+			"OBS_SCHEMA_VERSION":      "2",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, 5*time.Second, opts.TimeoutDuration)
+		assert.Equal(t, []string{"x-tenant", "region"}, opts.TracingTagHeaders)
+		assert.Empty(t, hook.AllEntries())
+	})
+
+	t.Run("Succeed with replacement taking precedence", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		envVariables := map[string]string{
+			"OBS_TIMEOUT":         "1m",
+			"OBS_INGRESS_TIMEOUT": "5s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, 5*time.Second, opts.TimeoutDuration)
+		assert.Len(t, hook.AllEntries(), 1)
+		assert.Equal(t, "TIMEOUT", hook.LastEntry().Data["setting"])
+	})
+
+	t.Run("Failing: newer schema version", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_SCHEMA_VERSION": "3",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func TestCMDOptionsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "options")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		assert.Nil(t, err)
+		return path
+	}
+
+	t.Run("Succeed with options file", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_OPTIONS_FILE": writeFile("options.yaml",
+				"service_version: 1.2.3\n"+
+					"service_namespace: from-file\n"+
+					"egress_timeout: 7s\n",
+			),
+			"OBS_SERVICE_NAMESPACE": "shop",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, "1.2.3", opts.ServiceVersion)
+		assert.Equal(t, 7*time.Second, opts.EgressTimeoutDuration)
+		assert.Equal(t, "shop", opts.ServiceNamespace, "Environment variables should take precedence")
+	})
+
+	t.Run("Succeed with deprecated options file keys", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		envVariables := map[string]string{
+			"OBS_OPTIONS_FILE": writeFile("deprecated.yaml",
+				"timeout: 1m\n"+
+					"tracing_driver: jeager\n"+
+					"tracing_tag_headers: x-tenant region\n",
+			),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute, opts.TimeoutDuration)
+		assert.Equal(t, "jaeger", opts.TracingDriver)
+		assert.Equal(t, []string{"x-tenant", "region"}, opts.TracingTagHeaders)
+		warnings := map[string]*logrus.Entry{}
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				warnings[entry.Data["setting"].(string)] = entry
+			}
+		}
+		assert.Len(t, warnings, 3)
+		assert.Equal(t, "ingress_timeout", warnings["timeout"].Data["replacement"])
+	})
+
+	t.Run("Succeed without options file", func(t *testing.T) {
+		// When
+		opts, err := buildOptions()
+
+		// Then
+		assert.Nil(t, err)
+		assert.Empty(t, opts.ServiceVersion)
+		assert.Equal(t, 15*time.Second, opts.EgressTimeoutDuration)
+	})
+
+	t.Run("Succeed with TLS files", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_TLS_ENABLED":   "true",
+			"OBS_TLS_CERT_FILE": writeFile("tls.crt", "certificate"),
+			"OBS_TLS_KEY_FILE":  writeFile("tls.key", "key"),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		opts, err := buildOptions()
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, "certificate", opts.TLSCert)
+		assert.Equal(t, "key", opts.TLSKey)
+		assert.Contains(t, watchedFiles(), envVariables["OBS_TLS_CERT_FILE"])
+		assert.Contains(t, watchedFiles(), envVariables["OBS_TLS_KEY_FILE"])
+
+		_, _, err = generateReloadableConfig()
+		assert.NotNil(t, err, "Invalid certificates should be rejected")
+	})
+
+	t.Run("Failing: missing options file", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_OPTIONS_FILE": filepath.Join(dir, "missing.yaml"),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+
+	t.Run("Failing: options file with a newer schema", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_OPTIONS_FILE": writeFile("newer.yaml", "schema_version: 99\n"),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+		assert.Contains(t, err.Error(), "schema version [99]")
+	})
+
+	t.Run("Failing: malformed options file", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_OPTIONS_FILE": writeFile("malformed.yaml", "service_version: [\n"),
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		_, err := buildOptions()
+
+		// Then
+		assert.NotNil(t, err, "Options instantiation should fail")
+	})
+}
+
+func setEnvironmentVariables(t *testing.T, envVars map[string]string) {
+	for k, v := range envVars {
+		err := os.Setenv(k, v)
+		assert.Nil(t, err)
+	}
+}
+
+func unsetEnvironmentVariables(t *testing.T, envVars map[string]string) {
+	for k := range envVars {
+		err := os.Unsetenv(k)
+		assert.Nil(t, err)
+	}
+}
+
+func unmarshalConfig(serializedConfig []byte) (envoy.Config, error) {
+	c := envoy.Config{}
+	err := yaml.Unmarshal(serializedConfig, &c)
+	return c, err
+}
+
+func TestCMDXDS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	newReloader := func(server *xds.Server) *reload.Reloader {
+		generate, commit := xdsConfig(server, "/tmp/xds.sock")
+		return &reload.Reloader{
+			ConfigPath: filepath.Join(dir, "envoy.yaml"),
+			Generate:   generate,
+			Commit:     commit,
+		}
+	}
+
+	t.Run("Succeed with dynamic resources", func(t *testing.T) {
+		server := xds.NewServer()
+
+		// When
+		serialized, _, _, err := generateXDSConfig("/tmp/xds.sock")
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, "0", server.Version(), "Resources should only be published once accepted")
+
+		c := envoy.Config{}
+		assert.Nil(t, yaml.Unmarshal(serialized, &c))
+		assert.Empty(t, c.StaticResources.Listeners)
+		assert.NotNil(t, c.DynamicResources)
+		assert.Equal(t, xds.ClusterName, c.StaticResources.Clusters[len(c.StaticResources.Clusters)-1].Name)
+	})
+
+	t.Run("Succeed with sampling changes without a new bootstrap", func(t *testing.T) {
+		server := xds.NewServer()
+		r := newReloader(server)
+		assert.Nil(t, r.Load())
+		assert.Equal(t, "1", server.Version())
+		bootstrap, err := ioutil.ReadFile(r.ConfigPath)
+		assert.Nil(t, err)
+
+		envVariables := map[string]string{
+			"OBS_TRACING_SAMPLING": "10",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		updated, resources, _, err := generateXDSConfig("/tmp/xds.sock")
+		assert.Nil(t, err)
+		err = server.Update(resources)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, string(bootstrap), string(updated))
+		assert.Equal(t, "2", server.Version())
+	})
+
+	t.Run("Failing: rejected bootstrap is not published", func(t *testing.T) {
+		server := xds.NewServer()
+		r := newReloader(server)
+		r.Validate = func(path string) error {
+			return merry.New("rejected")
+		}
+
+		// When
+		err := r.Load()
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Equal(t, "0", server.Version())
+	})
+}
+
+func TestCMDFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	readResponse := func(dir, name string) map[string]interface{} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		response := map[string]interface{}{}
+		assert.Nil(t, yaml.Unmarshal(content, &response))
+		return response
+	}
+
+	newReloader := func() (*reload.Reloader, string) {
+		resources, err := ioutil.TempDir(dir, "")
+		assert.Nil(t, err)
+		generate, commit := fileConfig(resources)
+		return &reload.Reloader{
+			ConfigPath: filepath.Join(dir, filepath.Base(resources)+".yaml"),
+			Generate:   generate,
+			Commit:     commit,
+		}, resources
+	}
+
+	t.Run("Succeed with discovery response files", func(t *testing.T) {
+		r, resources := newReloader()
+
+		// When
+		err := r.Load()
+
+		// Then
+		assert.Nil(t, err)
+
+		c := envoy.Config{}
+		serialized, err := ioutil.ReadFile(r.ConfigPath)
+		assert.Nil(t, err)
+		assert.Nil(t, yaml.Unmarshal(serialized, &c))
+		assert.Empty(t, c.StaticResources.Listeners)
+		assert.Equal(t, 1, len(c.StaticResources.Clusters), "Only the tracing cluster should be static")
+		assert.Equal(t, filepath.Join(resources, "lds.yaml"), c.DynamicResources.LDSConfig.Path)
+		assert.Equal(t, filepath.Join(resources, "cds.yaml"), c.DynamicResources.CDSConfig.Path)
+
+		lds := readResponse(resources, "lds.yaml")
+		assert.NotEmpty(t, lds["version_info"])
+		listeners := lds["resources"].([]interface{})
+		assert.Equal(t, 3, len(listeners))
+		listener := listeners[0].(map[interface{}]interface{})
+		assert.Equal(t, envoy.ListenerType, listener["@type"])
+		assert.Equal(t, "ingress_listener", listener["name"])
+
+		cds := readResponse(resources, "cds.yaml")
+		cluster := cds["resources"].([]interface{})[0].(map[interface{}]interface{})
+		assert.Equal(t, envoy.ClusterType, cluster["@type"])
+		assert.Equal(t, "h1_ingress_cluster", cluster["name"])
+	})
+
+	t.Run("Succeed with sampling changes without a new bootstrap", func(t *testing.T) {
+		r, resources := newReloader()
+		assert.Nil(t, r.Load())
+		bootstrap, err := ioutil.ReadFile(r.ConfigPath)
+		assert.Nil(t, err)
+		lds := readResponse(resources, "lds.yaml")
+		cds := readResponse(resources, "cds.yaml")
+
+		envVariables := map[string]string{
+			"OBS_TRACING_SAMPLING": "10",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		err = r.Load()
+
+		// Then
+		assert.Nil(t, err)
+		updated, err := ioutil.ReadFile(r.ConfigPath)
+		assert.Nil(t, err)
+		assert.Equal(t, string(bootstrap), string(updated))
+		assert.NotEqual(t, lds["version_info"], readResponse(resources, "lds.yaml")["version_info"])
+		assert.Equal(t, cds["version_info"], readResponse(resources, "cds.yaml")["version_info"])
+
+		files, err := ioutil.ReadDir(resources)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(files), "Temporary files should be removed")
+	})
+
+	t.Run("Failing: rejected bootstrap does not replace the resources", func(t *testing.T) {
+		r, resources := newReloader()
+		assert.Nil(t, r.Load())
+		lds := readResponse(resources, "lds.yaml")
+
+		envVariables := map[string]string{
+			"OBS_TRACING_SAMPLING": "10",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+		r.Validate = func(path string) error {
+			return merry.New("rejected")
+		}
+
+		// When
+		err := r.Load()
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Equal(t, lds["version_info"], readResponse(resources, "lds.yaml")["version_info"])
+	})
+
+	t.Run("Failing: rejected first bootstrap only leaves empty resources", func(t *testing.T) {
+		r, resources := newReloader()
+		r.Validate = func(path string) error {
+			return merry.New("rejected")
+		}
+
+		// When
+		err := r.Load()
+
+		// Then
+		assert.NotNil(t, err)
+		lds := readResponse(resources, "lds.yaml")
+		assert.Empty(t, lds["version_info"])
+		assert.Empty(t, lds["resources"])
+	})
+}
+
+func TestCMDRuntime(t *testing.T) {
+	t.Run("Succeed with layered runtime", func(t *testing.T) {
+		envVariables := map[string]string{
+			"OBS_RUNTIME_DIR": "/etc/observer/runtime/..data",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		serialized, err := run()
+
+		// Then
+		assert.Nil(t, err)
+		c := envoy.Config{}
+		assert.Nil(t, yaml.Unmarshal(serialized, &c))
+		layers := c.LayeredRuntime.Layers
+		assert.Equal(t, 2, len(layers))
+		assert.Equal(t, "/etc/observer/runtime/..data", layers[0].DiskLayer.SymlinkRoot)
+		assert.NotNil(t, layers[1].AdminLayer, "Admin values should override disk values")
+		assert.Equal(t, float64(100), c.StaticResources.Listeners[0].FilterChains[0].Filters[0].TypedConfig.Tracing.ClientSampling.Value)
+	})
+
+	t.Run("Succeed without runtime directory", func(t *testing.T) {
+		// When
+		opts, err := buildOptions()
+
+		// Then
+		assert.Nil(t, err)
+		c, err := envoy.New(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(c.LayeredRuntime.Layers))
+		assert.NotNil(t, c.LayeredRuntime.Layers[0].AdminLayer)
+	})
+
+	t.Run("Succeed setting runtime values", func(t *testing.T) {
+		var query url.Values
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/runtime_modify", r.URL.Path)
+			query = r.URL.Query()
+			w.Write([]byte("OK\n"))
+		}))
+		defer server.Close()
+
+		// When
+		err := runRuntime([]string{"set", "-admin-url", server.URL, "tracing.random_sampling=2500", "custom.key=on"})
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, "2500", query.Get("tracing.random_sampling"))
+		assert.Equal(t, "on", query.Get("custom.key"))
+	})
+
+	t.Run("Succeed removing runtime values", func(t *testing.T) {
+		// When
+		values, err := parseRuntimeValues([]string{"tracing.random_sampling="})
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"tracing.random_sampling": ""}, values)
+	})
+
+	t.Run("Failing: out of range value", func(t *testing.T) {
+		// When
+		_, err := parseRuntimeValues([]string{"tracing.global_enabled=101"})
+
+		// Then
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Failing: non integer value", func(t *testing.T) {
+		// When
+		_, err := parseRuntimeValues([]string{"tracing.random_sampling=5%"})
+
+		// Then
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Failing: missing values", func(t *testing.T) {
+		// When
+		err := runRuntime([]string{"set"})
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
+
+// fakeAdmin serves an admin API whose request counters grow by 10 requests,
+// 1 of them failed, every time stats are read.
+func fakeAdmin(t *testing.T) *httptest.Server {
+	reads := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/server_info":
+			w.Write([]byte(`{"version": "abc/1.13.1/Clean/RELEASE/BoringSSL", "state": "LIVE", "uptime_all_epochs": "90s", "command_line_options": {"restart_epoch": 2}}`))
+		case "/ready":
+			w.Write([]byte("LIVE\n"))
+		case "/stats":
+			reads++
+			w.Write([]byte(`{"stats": [
+				{"name": "http.h1_ingress.downstream_rq_total", "value": ` + strconv.Itoa(10*reads) + `},
+				{"name": "http.h1_ingress.downstream_rq_5xx", "value": ` + strconv.Itoa(reads) + `},
+				{"name": "http.h2_ingress.downstream_rq_active", "value": 3},
+				{"name": "tracing.zipkin.reports_sent", "value": 4}
+			]}`))
+		case "/clusters":
+			w.Write([]byte(`{"cluster_statuses": [
+				{"name": "h1_egress_cluster", "host_statuses": [
+					{"health_status": {"eds_health_status": "HEALTHY"}},
+					{"health_status": {"eds_health_status": "HEALTHY", "failed_outlier_check": true}}
+				]},
+				{"name": "tracing_zipkin_cluster", "host_statuses": [
+					{"health_status": {"eds_health_status": "UNHEALTHY"}}
+				]}
+			]}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestCMDStatus(t *testing.T) {
+	t.Run("Succeed summarizing traffic", func(t *testing.T) {
+		before := admin.Stats{
+			"http.h1_egress.downstream_rq_total": 100,
+			"http.h2_egress.downstream_rq_total": 50,
+			"http.h2_egress.downstream_rq_5xx":   5,
+			"tcp.egress_tcp.downstream_cx_total": 10,
+		}
+		after := admin.Stats{
+			"http.h1_egress.downstream_rq_total": 120,
+			"http.h2_egress.downstream_rq_total": 70,
+			"http.h2_egress.downstream_rq_5xx":   9,
+			"tcp.egress_tcp.downstream_cx_total": 12,
+		}
+
+		// When
+		s := summarize(before, after, 2*time.Second, nil)
+
+		// Then
+		assert.Equal(t, envoy.INGRESS, s.Directions[0].Direction)
+		assert.Equal(t, float64(0), s.Directions[0].RequestRate)
+		egress := s.Directions[1]
+		assert.Equal(t, float64(20), egress.RequestRate)
+		assert.Equal(t, float64(2), egress.ErrorRate)
+		assert.Equal(t, float64(1), egress.ConnectionRate)
+		assert.Nil(t, s.Tracer)
+	})
+
+	t.Run("Succeed with reset counters", func(t *testing.T) {
+		// When
+		s := summarize(admin.Stats{"http.h1_ingress.downstream_rq_total": 100}, admin.Stats{"http.h1_ingress.downstream_rq_total": 5}, time.Second, nil)
+
+		// Then
+		assert.Equal(t, float64(0), s.Directions[0].RequestRate)
+	})
+
+	t.Run("Succeed against the admin API", func(t *testing.T) {
+		server := fakeAdmin(t)
+		defer server.Close()
+
+		// When
+		s, err := collectStatus(admin.New(server.URL), 10*time.Millisecond)
+
+		// Then
+		assert.Nil(t, err)
+		assert.True(t, s.Ready)
+		assert.Equal(t, "LIVE", s.State)
+		assert.Equal(t, 2, s.Server.CommandLineOptions.RestartEpoch)
+
+		ingress := s.Directions[0]
+		assert.True(t, ingress.RequestRate > 0)
+		assert.InDelta(t, ingress.RequestRate/10, ingress.ErrorRate, 0.001)
+		assert.Equal(t, uint64(3), ingress.ActiveRequests)
+
+		egress := s.Directions[1]
+		assert.Equal(t, 2, egress.UpstreamHosts)
+		assert.Equal(t, 1, egress.HealthyUpstream)
+
+		assert.Equal(t, 1, s.Tracer.Hosts)
+		assert.Equal(t, 0, s.Tracer.HealthyHosts)
+		assert.Equal(t, uint64(4), s.Tracer.ReportsSent)
+
+		var out strings.Builder
+		assert.Nil(t, s.write(&out))
+		assert.Contains(t, out.String(), "envoy abc/1.13.1/Clean/RELEASE/BoringSSL, LIVE, uptime 1m30s, restart epoch 2")
+		assert.Contains(t, out.String(), "1/2 healthy")
+		assert.Contains(t, out.String(), "tracer: 0/1 collector hosts healthy, 4 reports sent, 0 dropped")
+	})
+
+	t.Run("Failing: unreachable admin API", func(t *testing.T) {
+		server := fakeAdmin(t)
+		server.Close()
+
+		// When
+		err := runStatus([]string{"-admin-url", server.URL, "-interval", "0"})
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCMDWaitReady(t *testing.T) {
+	t.Run("Succeed once envoy is ready", func(t *testing.T) {
+		server := fakeAdmin(t)
+		defer server.Close()
+
+		// When
+		err := runWaitReady([]string{"-admin-url", server.URL, "-interval", "10ms"})
+
+		// Then
+		assert.Nil(t, err)
+	})
+
+	t.Run("Fail when envoy is not ready in time", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		// When
+		err := runWaitReady([]string{"-admin-url", server.URL, "-timeout", "50ms", "-interval", "10ms"})
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "envoy not ready after 50ms")
+	})
+
+	t.Run("Fail running missing commands", func(t *testing.T) {
+		server := fakeAdmin(t)
+		defer server.Close()
+
+		// When
+		err := runWaitReady([]string{"-admin-url", server.URL, "observer-missing-command"})
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
+
+// configDumpAdmin serves the generated config the way the admin API of an
+// Envoy running it reports it.
+func configDumpAdmin(t *testing.T) *httptest.Server {
+	return httptest.NewServer(configDumpHandler(t))
+}
+
+// configDumpHandler serves the config dump of an Envoy running the config
+// generated for the current options.
+func configDumpHandler(t *testing.T) http.Handler {
+	dump := configDump(t)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config_dump" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(dump))
+	})
+}
+
+func configDump(t *testing.T) string {
+	cfg, err := generateCheckedConfig()
+	assert.Nil(t, err)
+	tracing, err := xds.Tracing(cfg.Tracing)
+	assert.Nil(t, err)
+
+	marshaler := jsonpb.Marshaler{OrigName: true}
+	tracingJSON, err := marshaler.MarshalToString(tracing)
+	assert.Nil(t, err)
+	listeners := []string{}
+	for _, l := range cfg.StaticResources.Listeners {
+		listener, err := xds.Listener(l)
+		assert.Nil(t, err)
+		serialized, err := marshaler.MarshalToString(listener)
+		assert.Nil(t, err)
+		listeners = append(listeners, `{"listener": `+serialized+`}`)
+	}
+	clusters := []string{}
+	for _, c := range cfg.StaticResources.Clusters {
+		cluster, err := xds.Cluster(c)
+		assert.Nil(t, err)
+		serialized, err := marshaler.MarshalToString(cluster)
+		assert.Nil(t, err)
+		clusters = append(clusters, `{"cluster": `+serialized+`}`)
+	}
+
+	return `{"configs": [
+		{"@type": "type.googleapis.com/envoy.admin.v2alpha.BootstrapConfigDump", "bootstrap": {"tracing": ` + tracingJSON + `}},
+		{"@type": "type.googleapis.com/envoy.admin.v2alpha.ClustersConfigDump", "static_clusters": [` + strings.Join(clusters, ",") + `]},
+		{"@type": "type.googleapis.com/envoy.admin.v2alpha.ListenersConfigDump", "static_listeners": [` + strings.Join(listeners, ",") + `]}
+	]}`
+}
+
+func TestCMDDiff(t *testing.T) {
+	t.Run("Succeed without drift", func(t *testing.T) {
+		server := configDumpAdmin(t)
+		defer server.Close()
+
+		// When
+		err := runDiff([]string{"-live", "-admin-url", server.URL})
+
+		// Then
+		assert.Nil(t, err)
+	})
+
+	t.Run("Succeed with the plain admin port name", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(nil)
+		defer server.Close()
+		_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		assert.Nil(t, err)
+		envVariables := map[string]string{
+			"TRACING_SAMPLING": "10",
+			"ADMIN_PORT":       port,
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+		server.Config.Handler = configDumpHandler(t)
+		server.Start()
+
+		// When
+		err = runDiff([]string{"-live"})
+
+		// Then
+		assert.Nil(t, err, "The admin port should be read like the proxy container sets it")
+		opts, err := buildOptions()
+		assert.Nil(t, err)
+		assert.Equal(t, 9901, opts.AdminPort, "Only the admin commands should read plain names")
+		assert.Equal(t, float64(100), opts.TracingSampling, "Only the admin settings should be read from plain names")
+	})
+
+	t.Run("Failing: running config drifted", func(t *testing.T) {
+		server := configDumpAdmin(t)
+		defer server.Close()
+		envVariables := map[string]string{
+			"OBS_TRACING_SAMPLING": "10",
+			"OBS_EGRESS_TIMEOUT":   "30s",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		err := runDiff([]string{"--live", "-admin-url", server.URL})
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "2 differences", "The ingress and egress listeners should differ")
+	})
+
+	t.Run("Succeed ignoring drift from a rejected config", func(t *testing.T) {
+		server := configDumpAdmin(t)
+		defer server.Close()
+		dir, err := ioutil.TempDir("", "diff")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		configPath := filepath.Join(dir, "envoy.yaml")
+		assert.Nil(t, ioutil.WriteFile(rejectedPath(configPath), []byte("invalid config\n"), 0644))
+		envVariables := map[string]string{
+			"OBS_TRACING_SAMPLING": "10",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		err = runDiff([]string{"-live", "-ignore-rejected", "-config", configPath, "-admin-url", server.URL})
+
+		// Then
+		assert.Nil(t, err, "Drift should be ignored while observer run keeps the last good config")
+	})
+
+	t.Run("Failing: drift without a rejected config", func(t *testing.T) {
+		server := configDumpAdmin(t)
+		defer server.Close()
+		dir, err := ioutil.TempDir("", "diff")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		envVariables := map[string]string{
+			"OBS_TRACING_SAMPLING": "10",
+		}
+		setEnvironmentVariables(t, envVariables)
+		defer unsetEnvironmentVariables(t, envVariables)
+
+		// When
+		err = runDiff([]string{"-live", "-ignore-rejected", "-config", filepath.Join(dir, "envoy.yaml"), "-admin-url", server.URL})
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "drifted")
+	})
+
+	t.Run("Failing: unreachable admin API", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		// When
+		err := runDiff([]string{"-live", "-admin-url", server.URL})
+
+		// Then
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Failing: without -live", func(t *testing.T) {
+		// When
+		err := runDiff([]string{})
+
+		// Then
+		assert.NotNil(t, err)
+	})
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+	log "github.com/sirupsen/logrus"
+)
+
+func runRuntime(args []string) error {
+	if len(args) == 0 || args[0] != "set" {
+		return merry.New("usage: observer runtime set [-admin-url url] key=value...")
+	}
+
+	flags := flag.NewFlagSet("runtime set", flag.ContinueOnError)
+	adminURL := adminFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: observer runtime set [-admin-url url] key=value...")
+		flags.PrintDefaults()
+		fmt.Fprintln(flags.Output(), "\nruntime keys read by the generated config:")
+		for _, key := range envoy.RuntimeKeys {
+			fmt.Fprintf(flags.Output(), "  %s\n    \t%s\n", key.Name, key.Description)
+		}
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	values, err := parseRuntimeValues(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	client, err := newAdminClient(*adminURL)
+	if err != nil {
+		return err
+	}
+	if err := client.SetRuntime(values); err != nil {
+		return merry.Prepend(err, "failed to update the runtime")
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(os.Stdout, "%s=%s\n", key, values[key])
+	}
+	return nil
+}
+
+// parseRuntimeValues parses key=value arguments. Values of known keys are
+// checked against their range, unknown keys are passed on with a warning.
+func parseRuntimeValues(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, merry.New("no runtime values given")
+	}
+	values, err := options.ParseKeyValues(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range values {
+		key, ok := envoy.LookupRuntimeKey(name)
+		if !ok {
+			log.WithField("key", name).Warn("runtime key is not read by the generated config")
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, merry.Errorf("invalid value [%s] for runtime key [%s]: expected an integer", value, name)
+		}
+		if err := key.Validate(n); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/omnition/omnition-observer/observer/pkg/admin"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+)
+
+func runStatus(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	adminURL := adminFlag(flags)
+	interval := flags.Duration("interval", time.Second, "time request rates are measured over")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := newAdminClient(*adminURL)
+	if err != nil {
+		return err
+	}
+	status, err := collectStatus(client, *interval)
+	if err != nil {
+		return err
+	}
+	return status.write(os.Stdout)
+}
+
+// directionStatus summarizes the traffic of a direction. Rates are per
+// second.
+type directionStatus struct {
+	Direction       envoy.TrafficDirection
+	RequestRate     float64
+	ErrorRate       float64
+	ConnectionRate  float64
+	ActiveRequests  uint64
+	UpstreamHosts   int
+	HealthyUpstream int
+}
+
+// tracerStatus summarizes the health of the zipkin collector cluster.
+type tracerStatus struct {
+	Hosts          int
+	HealthyHosts   int
+	ReportsSent    uint64
+	ReportsDropped uint64
+}
+
+type status struct {
+	Server     admin.ServerInfo
+	Ready      bool
+	State      string
+	Directions []directionStatus
+	// Tracer is nil when traces are not sent through a cluster
+	Tracer *tracerStatus
+}
+
+// collectStatus samples stats twice, interval apart, to compute rates.
+func collectStatus(client *admin.Client, interval time.Duration) (*status, error) {
+	info, err := client.ServerInfo()
+	if err != nil {
+		return nil, err
+	}
+	ready, state, err := client.Ready()
+	if err != nil {
+		return nil, err
+	}
+	before, err := client.Stats()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	time.Sleep(interval)
+	after, err := client.Stats()
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	clusters, err := client.Clusters()
+	if err != nil {
+		return nil, err
+	}
+
+	s := summarize(before, after, elapsed, clusters)
+	s.Server = info
+	s.Ready = ready
+	s.State = state
+	return s, nil
+}
+
+// summarize computes the traffic of each direction from two stats samples
+// and the upstream hosts of the generated clusters.
+func summarize(before, after admin.Stats, elapsed time.Duration, clusters []admin.ClusterStatus) *status {
+	rate := func(names []string) float64 {
+		delta := float64(after.Sum(names...)) - float64(before.Sum(names...))
+		if delta < 0 || elapsed <= 0 {
+			// Counters are reset when envoy restarts
+			return 0
+		}
+		return delta / elapsed.Seconds()
+	}
+
+	s := &status{}
+	for _, direction := range []envoy.TrafficDirection{envoy.INGRESS, envoy.EGRESS} {
+		d := directionStatus{Direction: direction}
+		var requests, errors, active []string
+		for _, protocol := range []envoy.Protocol{envoy.HTTP1, envoy.HTTP2} {
+			prefix := "http." + protocol.Label() + "_" + direction.Label() + "."
+			requests = append(requests, prefix+"downstream_rq_total")
+			errors = append(errors, prefix+"downstream_rq_5xx")
+			active = append(active, prefix+"downstream_rq_active")
+		}
+		d.RequestRate = rate(requests)
+		d.ErrorRate = rate(errors)
+		d.ConnectionRate = rate([]string{"tcp." + direction.Label() + "_tcp.downstream_cx_total"})
+		d.ActiveRequests = after.Sum(active...)
+
+		for _, c := range clusters {
+			if strings.HasSuffix(c.Name, "_"+direction.Label()+"_cluster") {
+				d.UpstreamHosts += len(c.HostStatuses)
+				d.HealthyUpstream += c.HealthyHosts()
+			}
+		}
+		s.Directions = append(s.Directions, d)
+	}
+
+	for _, c := range clusters {
+		if c.Name == envoy.TracingClusterName {
+			s.Tracer = &tracerStatus{
+				Hosts:          len(c.HostStatuses),
+				HealthyHosts:   c.HealthyHosts(),
+				ReportsSent:    after["tracing.zipkin.reports_sent"],
+				ReportsDropped: after["tracing.zipkin.reports_dropped"],
+			}
+		}
+	}
+	return s
+}
+
+func (s *status) write(w io.Writer) error {
+	fmt.Fprintf(w, "envoy %s, %s, uptime %s, restart epoch %d\n",
+		s.Server.Version, s.State,
+		time.Duration(s.Server.UptimeAllEpochs), s.Server.CommandLineOptions.RestartEpoch)
+	if s.Ready {
+		fmt.Fprintln(w, "ready")
+	} else {
+		fmt.Fprintln(w, "not ready")
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIRECTION\tREQUESTS/S\t5XX/S\tACTIVE REQUESTS\tTCP CONNECTIONS/S\tUPSTREAM HOSTS")
+	for _, d := range s.Directions {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%d\t%.1f\t%d/%d healthy\n",
+			d.Direction.Label(), d.RequestRate, d.ErrorRate, d.ActiveRequests, d.ConnectionRate,
+			d.HealthyUpstream, d.UpstreamHosts)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	if s.Tracer == nil {
+		_, err := fmt.Fprintln(w, "tracer: no collector cluster")
+		return err
+	}
+	_, err := fmt.Fprintf(w, "tracer: %d/%d collector hosts healthy, %d reports sent, %d dropped\n",
+		s.Tracer.HealthyHosts, s.Tracer.Hosts, s.Tracer.ReportsSent, s.Tracer.ReportsDropped)
+	return err
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/reload"
+	"github.com/omnition/omnition-observer/observer/pkg/supervisor"
+	"github.com/omnition/omnition-observer/observer/pkg/xds"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Modes envoy can receive its listeners and clusters in
+const (
+	staticMode = "static"
+	xdsMode    = "xds"
+	filesMode  = "files"
+)
+
+func runSupervisor(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	configPath := flags.String("config", "/etc/envoy.yaml", "path the generated envoy config is written to")
+	envoyPath := flags.String("envoy", "envoy", "envoy binary")
+	logLevel := flags.String("log-level", "info", "envoy log level")
+	group := flags.String("group", "", "group name or ID envoy runs with")
+	drainTime := flags.Duration("drain-time", 5*time.Second, "time envoy keeps serving after failing health checks on shutdown")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 20*time.Second, "time envoy may take to exit before it is killed")
+	minBackoff := flags.Duration("restart-backoff", time.Second, "initial delay before restarting a crashed envoy")
+	maxBackoff := flags.Duration("max-restart-backoff", 30*time.Second, "maximum delay before restarting a crashed envoy")
+	maxRestarts := flags.Int("max-restarts", 5, "consecutive crashes tolerated before giving up, negative values never give up")
+	reloadInterval := flags.Duration("reload-interval", 5*time.Second, "interval option files are checked for changes at, 0 disables reloads")
+	validate := flags.Bool("validate", true, "validate new configs with envoy before reloading")
+	mode := flags.String("mode", staticMode, "how envoy receives listeners, clusters and secrets: static, xds or files")
+	xdsSocket := flags.String("xds-socket", "/var/lib/omnition/proxy/xds.sock", "unix socket the control plane listens on in xds mode")
+	resourcesDir := flags.String("resources-dir", "", "directory listener and cluster files are written to in files mode, defaults to the config directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	gid, err := lookupGroup(*group)
+	if err != nil {
+		return err
+	}
+
+	opts, err := buildOptions()
+	if err != nil {
+		return err
+	}
+
+	var generate reload.Generator = generateReloadableConfig
+	var commit func() error
+	switch *mode {
+	case staticMode:
+	case xdsMode:
+		server := xds.NewServer()
+		l, err := xds.Listen(*xdsSocket)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := server.Serve(l); err != nil {
+				log.WithField("error", err.Error()).Error("control plane stopped")
+			}
+		}()
+		defer server.Stop()
+		generate, commit = xdsConfig(server, *xdsSocket)
+	case filesMode:
+		dir := *resourcesDir
+		if dir == "" {
+			dir = filepath.Dir(*configPath)
+		}
+		generate, commit = fileConfig(dir)
+	default:
+		return merry.Errorf("invalid mode [%s]. Supported values are: %s, %s, %s", *mode, staticMode, xdsMode, filesMode)
+	}
+
+	reloader := &reload.Reloader{
+		ConfigPath:   *configPath,
+		Generate:     generate,
+		Commit:       commit,
+		Interval:     *reloadInterval,
+		RejectedPath: rejectedPath(*configPath),
+	}
+	if *validate {
+		reloader.Validate = func(path string) error {
+			return validateEnvoyConfig(*envoyPath, path)
+		}
+	}
+	if err := reloader.Load(); err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	var reloads chan struct{}
+	if *reloadInterval > 0 {
+		reloads = make(chan struct{})
+		stop := make(chan struct{})
+		defer close(stop)
+		go reloader.Watch(stop, reloads)
+	}
+
+	s := &supervisor.Supervisor{
+		EnvoyPath:       *envoyPath,
+		Args:            []string{"-c", *configPath, "-l", *logLevel},
+		GID:             gid,
+		AdminURL:        envoy.AdminURL(opts),
+		DrainTime:       *drainTime,
+		ShutdownTimeout: *shutdownTimeout,
+		MinBackoff:      *minBackoff,
+		MaxBackoff:      *maxBackoff,
+		MaxRestarts:     *maxRestarts,
+		Stdout:          os.Stdout,
+		Stderr:          os.Stderr,
+	}
+	status, err := s.Run(signals, reloads)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		log.WithField("status", status).Error("envoy exited with an error")
+		return exitStatus(status)
+	}
+	return nil
+}
+
+// lookupGroup resolves a group name or ID. An empty group returns -1.
+func lookupGroup(group string) (int, error) {
+	if group == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, merry.Wrap(err)
+	}
+	return gid, nil
+}
+
+// generateReloadableConfig builds the serialized config and returns the
+// files it depends on.
+func generateReloadableConfig() ([]byte, []string, error) {
+	generated, err := generateCheckedConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	serialized, err := yaml.Marshal(&generated)
+	if err != nil {
+		return nil, nil, merry.Wrap(err)
+	}
+	return serialized, watchedFiles(), nil
+}
+
+// xdsConfig returns the generator of bootstraps served by the control plane
+// and the commit publishing the resources generated with the accepted
+// bootstrap.
+func xdsConfig(server *xds.Server, socket string) (reload.Generator, func() error) {
+	var pending envoy.Resources
+	generate := func() ([]byte, []string, error) {
+		serialized, resources, files, err := generateXDSConfig(socket)
+		pending = resources
+		return serialized, files, err
+	}
+	commit := func() error {
+		return server.Update(pending)
+	}
+	return generate, commit
+}
+
+// generateXDSConfig returns the serialized bootstrap, the listeners,
+// clusters and secrets to publish to the control plane once the bootstrap
+// is accepted, and the files they depend on. Changes that only touch the
+// published resources leave the bootstrap unchanged, so they apply without
+// a hot restart.
+func generateXDSConfig(socket string) ([]byte, envoy.Resources, []string, error) {
+	generated, err := generateCheckedConfig()
+	if err != nil {
+		return nil, envoy.Resources{}, nil, err
+	}
+	bootstrap, resources := xds.Bootstrap(*generated, socket)
+	serialized, err := yaml.Marshal(&bootstrap)
+	if err != nil {
+		return nil, envoy.Resources{}, nil, merry.Wrap(err)
+	}
+	return serialized, resources, watchedFiles(), nil
+}
+
+// fileConfig returns the generator of bootstraps watching discovery response
+// files in dir and the commit writing the listeners and clusters generated
+// with the accepted bootstrap. Clusters are written first so that listeners
+// never route to clusters envoy does not know yet.
+func fileConfig(dir string) (reload.Generator, func() error) {
+	ldsPath := filepath.Join(dir, "lds.yaml")
+	cdsPath := filepath.Join(dir, "cds.yaml")
+	var lds, cds envoy.DiscoveryResponse
+	generate := func() ([]byte, []string, error) {
+		serialized, l, c, files, err := generateFileConfig(ldsPath, cdsPath)
+		lds, cds = l, c
+		return serialized, files, err
+	}
+	commit := func() error {
+		if err := writeDiscoveryResponse(cdsPath, cds); err != nil {
+			return err
+		}
+		return writeDiscoveryResponse(ldsPath, lds)
+	}
+	return generate, commit
+}
+
+// generateFileConfig returns the serialized bootstrap watching the discovery
+// response files at ldsPath and cdsPath, the responses to write to them once
+// the bootstrap is accepted, and the files they depend on. Envoy refuses
+// bootstraps watching files that do not exist, so empty responses are
+// written to missing files.
+func generateFileConfig(ldsPath, cdsPath string) ([]byte, envoy.DiscoveryResponse, envoy.DiscoveryResponse, []string, error) {
+	none := envoy.DiscoveryResponse{}
+	generated, err := generateCheckedConfig()
+	if err != nil {
+		return nil, none, none, nil, err
+	}
+	bootstrap, lds, cds, err := envoy.SplitFiles(*generated, ldsPath, cdsPath)
+	if err != nil {
+		return nil, none, none, nil, err
+	}
+	for _, path := range []string{cdsPath, ldsPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		empty, err := yaml.Marshal(&envoy.DiscoveryResponse{Resources: []interface{}{}})
+		if err != nil {
+			return nil, none, none, nil, merry.Wrap(err)
+		}
+		if err := reload.WriteFile(path, empty, nil); err != nil {
+			return nil, none, none, nil, err
+		}
+	}
+
+	serialized, err := yaml.Marshal(&bootstrap)
+	if err != nil {
+		return nil, none, none, nil, merry.Wrap(err)
+	}
+	return serialized, lds, cds, watchedFiles(), nil
+}
+
+// writeDiscoveryResponse replaces the file at path unless it already holds
+// the same version, since envoy reapplies every file it sees moved in.
+func writeDiscoveryResponse(path string, response envoy.DiscoveryResponse) error {
+	serialized, err := yaml.Marshal(&response)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, serialized) {
+		return nil
+	}
+	if err := reload.WriteFile(path, serialized, nil); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"path": path, "version": response.VersionInfo}).Info("published envoy resources")
+	return nil
+}
+
+// generateCheckedConfig builds the config after checking the inputs envoy
+// would only reject at runtime.
+func generateCheckedConfig() (*envoy.Config, error) {
+	opts, err := buildOptions()
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLSEnabled {
+		if _, err := tls.X509KeyPair([]byte(opts.TLSCert), []byte(opts.TLSKey)); err != nil {
+			return nil, merry.Prepend(err, "invalid TLS certificate")
+		}
+	}
+	return generateConfig(&opts)
+}
+
+// validateEnvoyConfig checks a config file with envoy's validate mode.
+func validateEnvoyConfig(envoyPath, path string) error {
+	out, err := exec.Command(envoyPath, "--mode", "validate", "-c", path).CombinedOutput()
+	if err != nil {
+		return merry.Errorf("envoy rejected the config: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
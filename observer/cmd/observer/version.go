@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+func runVersion(args []string) error {
+	fmt.Printf("observer %s\n", version)
+	fmt.Printf("options schema %d\n", options.SchemaVersion)
+	return nil
+}
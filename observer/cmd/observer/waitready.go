@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/ansel1/merry"
+	log "github.com/sirupsen/logrus"
+)
+
+// runWaitReady blocks until the local Envoy reports ready so that
+// applications do not make outbound calls before the proxy is listening.
+// When a command follows the flags it replaces the observer process once
+// Envoy is ready, so that wait-ready can wrap an application entrypoint.
+func runWaitReady(args []string) error {
+	flags := flag.NewFlagSet("wait-ready", flag.ContinueOnError)
+	adminURL := adminFlag(flags)
+	timeout := flags.Duration("timeout", time.Minute, "time to wait for envoy before failing")
+	interval := flags.Duration("interval", 500*time.Millisecond, "time between readiness checks")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: observer wait-ready [-admin-url url] [-timeout duration] [-interval duration] [command args...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := newAdminClient(*adminURL)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := client.WaitReady(*timeout, *interval); err != nil {
+		return err
+	}
+	log.WithField("waited", time.Since(start).Round(time.Millisecond).String()).Info("envoy is ready")
+
+	command := flags.Args()
+	if len(command) == 0 {
+		return nil
+	}
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Prependf(syscall.Exec(path, command, os.Environ()), "failed to run %s", command[0])
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/webhook"
+	log "github.com/sirupsen/logrus"
+)
+
+func runWebhook(args []string) error {
+	flags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	listen := flags.String("listen", ":8443", "address to serve the webhook on")
+	tlsCert := flags.String("tls-cert", "/etc/webhook/certs/tls.crt", "path to the TLS certificate")
+	tlsKey := flags.String("tls-key", "/etc/webhook/certs/tls.key", "path to the TLS private key")
+	cfg := injectFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    *listen,
+		Handler: webhook.NewServeMux(*cfg),
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	log.WithField("address", *listen).Info("serving admission webhook")
+	if err := server.ListenAndServeTLS(*tlsCert, *tlsKey); err != http.ErrServerClosed {
+		return merry.Wrap(err)
+	}
+	return nil
+}
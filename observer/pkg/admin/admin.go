@@ -0,0 +1,209 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Client talks to Envoy's admin API.
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// New returns a client for the admin API at url.
+func New(url string) *Client {
+	return &Client{
+		URL:  strings.TrimSuffix(url, "/"),
+		HTTP: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// SetRuntime sets values in the admin runtime layer. Empty values remove
+// the override so that lower layers apply again.
+func (c *Client) SetRuntime(values map[string]string) error {
+	query := url.Values{}
+	for key, value := range values {
+		query.Set(key, value)
+	}
+	_, err := c.post("/runtime_modify?" + query.Encode())
+	return err
+}
+
+// FailHealthChecks makes Envoy fail health checks so that no new traffic is
+// routed to it. With the default listener drain type connections are closed
+// once their current requests finish.
+func (c *Client) FailHealthChecks() error {
+	_, err := c.post("/healthcheck/fail")
+	return err
+}
+
+// DrainListeners stops every listener from accepting new connections.
+// Established connections are kept until they close.
+func (c *Client) DrainListeners() error {
+	_, err := c.post("/drain_listeners")
+	return err
+}
+
+// Stats returns the current value of every counter and gauge.
+func (c *Client) Stats() (Stats, error) {
+	var response struct {
+		Stats []struct {
+			Name  string
+			Value uint64
+		}
+	}
+	if err := c.getJSON("/stats?format=json", &response); err != nil {
+		return nil, err
+	}
+	stats := Stats{}
+	for _, stat := range response.Stats {
+		// Histograms are reported in an entry without a name
+		if stat.Name != "" {
+			stats[stat.Name] = stat.Value
+		}
+	}
+	return stats, nil
+}
+
+// Clusters returns the upstream hosts of every cluster.
+func (c *Client) Clusters() ([]ClusterStatus, error) {
+	var response struct {
+		ClusterStatuses []ClusterStatus `json:"cluster_statuses"`
+	}
+	if err := c.getJSON("/clusters?format=json", &response); err != nil {
+		return nil, err
+	}
+	return response.ClusterStatuses, nil
+}
+
+// ServerInfo returns the version and state of the Envoy process.
+func (c *Client) ServerInfo() (ServerInfo, error) {
+	info := ServerInfo{}
+	err := c.getJSON("/server_info", &info)
+	return info, err
+}
+
+// Ready reports whether Envoy finished initializing and is not draining,
+// along with the server state.
+func (c *Client) Ready() (bool, string, error) {
+	resp, err := c.HTTP.Get(c.URL + "/ready")
+	if err != nil {
+		return false, "", merry.Wrap(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, "", merry.Wrap(err)
+	}
+	state := strings.TrimSpace(string(body))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, state, nil
+	case http.StatusServiceUnavailable:
+		return false, state, nil
+	}
+	return false, "", merry.Errorf("unexpected status [%d]: %s", resp.StatusCode, state)
+}
+
+// WaitReady polls Ready every interval until Envoy is ready or timeout
+// elapses. Connection errors are retried since the admin API only starts
+// listening once Envoy has loaded its config.
+func (c *Client) WaitReady(timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ready, state, err := c.Ready()
+		if ready {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if err != nil {
+				return merry.Prependf(err, "envoy not ready after %s", timeout)
+			}
+			return merry.Errorf("envoy not ready after %s: %s", timeout, state)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// ConfigDump returns the bootstrap, listeners and clusters Envoy is
+// running with.
+func (c *Client) ConfigDump() (*ConfigDump, error) {
+	var response struct {
+		Configs []configDumpSection
+	}
+	if err := c.getJSON("/config_dump", &response); err != nil {
+		return nil, err
+	}
+
+	dump := &ConfigDump{}
+	for _, section := range response.Configs {
+		switch {
+		case strings.HasSuffix(section.Type, ".BootstrapConfigDump"):
+			dump.Bootstrap = section.Bootstrap
+		case strings.HasSuffix(section.Type, ".ListenersConfigDump"):
+			for _, l := range section.StaticListeners {
+				dump.Listeners = append(dump.Listeners, l.Listener)
+			}
+			for _, l := range section.DynamicActiveListeners {
+				dump.Listeners = append(dump.Listeners, l.Listener)
+			}
+			for _, l := range section.DynamicListeners {
+				if l.ActiveState != nil {
+					dump.Listeners = append(dump.Listeners, l.ActiveState.Listener)
+				}
+			}
+		case strings.HasSuffix(section.Type, ".ClustersConfigDump"):
+			for _, c := range section.StaticClusters {
+				dump.Clusters = append(dump.Clusters, c.Cluster)
+			}
+			for _, c := range section.DynamicActiveClusters {
+				dump.Clusters = append(dump.Clusters, c.Cluster)
+			}
+		}
+	}
+	return dump, nil
+}
+
+func (c *Client) getJSON(path string, v interface{}) error {
+	resp, err := c.HTTP.Get(c.URL + path)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	body, err := readResponse(resp)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return merry.Prepend(err, "invalid response from "+path)
+	}
+	return nil
+}
+
+func (c *Client) post(path string) ([]byte, error) {
+	resp, err := c.HTTP.Post(c.URL+path, "text/plain", nil)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return readResponse(resp)
+}
+
+// readResponse returns the body of successful responses and turns other
+// responses into errors carrying the admin API's message.
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, merry.Errorf("unexpected status [%d]: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,286 @@
+package admin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAdmin serves canned responses per path and records requests.
+type fakeAdmin struct {
+	responses map[string]string
+	status    int
+	requests  []*http.Request
+}
+
+func (f *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r)
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		w.Write([]byte("admin failure\n"))
+		return
+	}
+	body, ok := f.responses[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(body))
+}
+
+func fixture(t *testing.T, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	require.Nil(t, err)
+	return string(content)
+}
+
+func newFakeAdmin(responses map[string]string) (*fakeAdmin, *Client, func()) {
+	fake := &fakeAdmin{responses: responses}
+	server := httptest.NewServer(fake)
+	return fake, New(server.URL + "/"), server.Close
+}
+
+func TestSetRuntime(t *testing.T) {
+	t.Run("Should post values to the admin layer", func(t *testing.T) {
+		fake, client, stop := newFakeAdmin(map[string]string{"/runtime_modify": "OK\n"})
+		defer stop()
+
+		// When
+		err := client.SetRuntime(map[string]string{"tracing.random_sampling": "500", "tracing.global_enabled": ""})
+
+		// Then
+		require.Nil(t, err)
+		require.Len(t, fake.requests, 1)
+		assert.Equal(t, http.MethodPost, fake.requests[0].Method)
+		assert.Equal(t, url.Values{
+			"tracing.random_sampling": []string{"500"},
+			"tracing.global_enabled":  []string{""},
+		}, fake.requests[0].URL.Query())
+	})
+
+	t.Run("Should report admin API errors", func(t *testing.T) {
+		fake, client, stop := newFakeAdmin(nil)
+		defer stop()
+		fake.status = http.StatusServiceUnavailable
+
+		// When
+		err := client.SetRuntime(map[string]string{"tracing.random_sampling": "500"})
+
+		// Then
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "503")
+		assert.Contains(t, err.Error(), "admin failure")
+	})
+}
+
+func TestStats(t *testing.T) {
+	_, client, stop := newFakeAdmin(map[string]string{"/stats": fixture(t, "stats.json")})
+	defer stop()
+
+	// When
+	stats, err := client.Stats()
+
+	// Then
+	require.Nil(t, err)
+	assert.Len(t, stats, 5, "Histograms should be skipped")
+	assert.Equal(t, uint64(120), stats["http.h1_egress.downstream_rq_total"])
+	assert.Equal(t, uint64(123), stats.Sum("http.h1_egress.downstream_rq_total", "http.h1_egress.downstream_rq_5xx", "missing"))
+}
+
+func TestActiveConnections(t *testing.T) {
+	stats := Stats{
+		"listener.0.0.0.0_15001.downstream_cx_active": 3,
+		"listener.0.0.0.0_15002.downstream_cx_active": 2,
+		"listener.0.0.0.0_15002.downstream_cx_total":  40,
+		"listener.admin.downstream_cx_active":         1,
+		"http.h1_egress.downstream_cx_active":         2,
+	}
+
+	// When
+	active := stats.ActiveConnections()
+
+	// Then
+	assert.Equal(t, uint64(5), active, "Admin connections should not count")
+}
+
+func TestDrain(t *testing.T) {
+	t.Run("Should fail health checks and drain listeners", func(t *testing.T) {
+		fake, client, stop := newFakeAdmin(map[string]string{"/healthcheck/fail": "OK\n", "/drain_listeners": "OK\n"})
+		defer stop()
+
+		// When
+		require.Nil(t, client.FailHealthChecks())
+		require.Nil(t, client.DrainListeners())
+
+		// Then
+		require.Len(t, fake.requests, 2)
+		for _, r := range fake.requests {
+			assert.Equal(t, http.MethodPost, r.Method)
+		}
+		assert.Equal(t, "/healthcheck/fail", fake.requests[0].URL.Path)
+		assert.Equal(t, "/drain_listeners", fake.requests[1].URL.Path)
+	})
+
+	t.Run("Should report admin API errors", func(t *testing.T) {
+		_, client, stop := newFakeAdmin(nil)
+		defer stop()
+
+		// When
+		err := client.DrainListeners()
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
+
+func TestClusters(t *testing.T) {
+	_, client, stop := newFakeAdmin(map[string]string{"/clusters": fixture(t, "clusters.json")})
+	defer stop()
+
+	// When
+	clusters, err := client.Clusters()
+
+	// Then
+	require.Nil(t, err)
+	require.Len(t, clusters, 2)
+	egress := clusters[0]
+	assert.Equal(t, "h1_egress_cluster", egress.Name)
+	require.Len(t, egress.HostStatuses, 2)
+	assert.Equal(t, "10.0.0.5", egress.HostStatuses[0].Address.SocketAddress.Address)
+	assert.Equal(t, uint64(2), egress.HostStatuses[0].Stat("cx_active"))
+	assert.Equal(t, uint64(0), egress.HostStatuses[1].Stat("cx_active"))
+	assert.Equal(t, 1, egress.HealthyHosts(), "Hosts ejected by outlier detection should be unhealthy")
+	assert.Equal(t, 1, clusters[1].HealthyHosts())
+}
+
+func TestServerInfo(t *testing.T) {
+	_, client, stop := newFakeAdmin(map[string]string{"/server_info": fixture(t, "server_info.json")})
+	defer stop()
+
+	// When
+	info, err := client.ServerInfo()
+
+	// Then
+	require.Nil(t, err)
+	assert.Equal(t, "LIVE", info.State)
+	assert.Contains(t, info.Version, "1.13.1")
+	assert.Equal(t, time.Hour, time.Duration(info.UptimeAllEpochs))
+	assert.Equal(t, 30*time.Second, time.Duration(info.UptimeCurrentEpoch))
+	assert.Equal(t, 1, info.CommandLineOptions.RestartEpoch)
+}
+
+func TestReady(t *testing.T) {
+	t.Run("Should report live servers as ready", func(t *testing.T) {
+		_, client, stop := newFakeAdmin(map[string]string{"/ready": "LIVE\n"})
+		defer stop()
+
+		// When
+		ready, state, err := client.Ready()
+
+		// Then
+		require.Nil(t, err)
+		assert.True(t, ready)
+		assert.Equal(t, "LIVE", state)
+	})
+
+	t.Run("Should report initializing servers as not ready", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("INITIALIZING\n"))
+		}))
+		defer server.Close()
+
+		// When
+		ready, state, err := New(server.URL).Ready()
+
+		// Then
+		require.Nil(t, err)
+		assert.False(t, ready)
+		assert.Equal(t, "INITIALIZING", state)
+	})
+
+	t.Run("Should report unreachable servers", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		// When
+		_, _, err := New(server.URL).Ready()
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
+
+func TestWaitReady(t *testing.T) {
+	t.Run("Should wait until the server is ready", func(t *testing.T) {
+		polls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			polls++
+			if polls < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("PRE_INITIALIZING\n"))
+				return
+			}
+			w.Write([]byte("LIVE\n"))
+		}))
+		defer server.Close()
+
+		// When
+		err := New(server.URL).WaitReady(5*time.Second, time.Millisecond)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 3, polls)
+	})
+
+	t.Run("Should report the state on timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("DRAINING\n"))
+		}))
+		defer server.Close()
+
+		// When
+		err := New(server.URL).WaitReady(20*time.Millisecond, time.Millisecond)
+
+		// Then
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "envoy not ready after 20ms: DRAINING")
+	})
+
+	t.Run("Should retry unreachable servers until the timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		// When
+		err := New(server.URL).WaitReady(20*time.Millisecond, time.Millisecond)
+
+		// Then
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "envoy not ready after 20ms")
+	})
+}
+
+func TestConfigDump(t *testing.T) {
+	_, client, stop := newFakeAdmin(map[string]string{"/config_dump": fixture(t, "config_dump.json")})
+	defer stop()
+
+	// When
+	dump, err := client.ConfigDump()
+
+	// Then
+	require.Nil(t, err)
+	assert.Equal(t, "my-pod", dump.Bootstrap["node"].(map[string]interface{})["id"])
+	require.Len(t, dump.Clusters, 2)
+	assert.Equal(t, "tracing_zipkin_cluster", dump.Clusters[0]["name"])
+	assert.Equal(t, "h1_ingress_cluster", dump.Clusters[1]["name"])
+	require.Len(t, dump.Listeners, 1)
+	assert.Equal(t, "ingress_listener", dump.Listeners[0]["name"])
+}
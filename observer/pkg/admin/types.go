@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// Stats maps stat names to the value of the counter or gauge.
+type Stats map[string]uint64
+
+// Sum adds up the stats with the given names.
+func (s Stats) Sum(names ...string) uint64 {
+	var sum uint64
+	for _, name := range names {
+		sum += s[name]
+	}
+	return sum
+}
+
+// ActiveConnections returns the connections open on every listener except
+// the admin API's.
+func (s Stats) ActiveConnections() uint64 {
+	var sum uint64
+	for name, value := range s {
+		if strings.HasPrefix(name, "listener.") && strings.HasSuffix(name, ".downstream_cx_active") &&
+			name != "listener.admin.downstream_cx_active" {
+			sum += value
+		}
+	}
+	return sum
+}
+
+// Duration parses the "123s" durations the admin API reports.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type CommandLineOptions struct {
+	RestartEpoch int `json:"restart_epoch"`
+}
+
+type ServerInfo struct {
+	Version            string             `json:"version"`
+	State              string             `json:"state"`
+	UptimeCurrentEpoch Duration           `json:"uptime_current_epoch"`
+	UptimeAllEpochs    Duration           `json:"uptime_all_epochs"`
+	CommandLineOptions CommandLineOptions `json:"command_line_options"`
+}
+
+type SocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+type Address struct {
+	SocketAddress SocketAddress `json:"socket_address"`
+}
+
+type HostStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value,string"`
+}
+
+type HealthStatus struct {
+	FailedActiveHealthCheck bool   `json:"failed_active_health_check"`
+	FailedOutlierCheck      bool   `json:"failed_outlier_check"`
+	EDSHealthStatus         string `json:"eds_health_status"`
+}
+
+// Healthy reports whether Envoy routes requests to the host. Unknown
+// statuses are treated as healthy like Envoy does.
+func (s HealthStatus) Healthy() bool {
+	if s.FailedActiveHealthCheck || s.FailedOutlierCheck {
+		return false
+	}
+	switch s.EDSHealthStatus {
+	case "UNHEALTHY", "DRAINING", "TIMEOUT":
+		return false
+	}
+	return true
+}
+
+type HostStatus struct {
+	Address      Address      `json:"address"`
+	Stats        []HostStat   `json:"stats"`
+	HealthStatus HealthStatus `json:"health_status"`
+}
+
+// Stat returns the value of a host stat, zero when it is not reported.
+func (h HostStatus) Stat(name string) uint64 {
+	for _, stat := range h.Stats {
+		if stat.Name == name {
+			return stat.Value
+		}
+	}
+	return 0
+}
+
+type ClusterStatus struct {
+	Name         string       `json:"name"`
+	HostStatuses []HostStatus `json:"host_statuses"`
+}
+
+// HealthyHosts returns the number of hosts Envoy routes requests to.
+func (c ClusterStatus) HealthyHosts() int {
+	healthy := 0
+	for _, host := range c.HostStatuses {
+		if host.HealthStatus.Healthy() {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// ConfigDump holds the config sections of a running Envoy in their JSON
+// form, decoded into generic values.
+type ConfigDump struct {
+	Bootstrap map[string]interface{}
+	Listeners []map[string]interface{}
+	Clusters  []map[string]interface{}
+}
+
+type dumpedListener struct {
+	Listener map[string]interface{} `json:"listener"`
+}
+
+type dumpedCluster struct {
+	Cluster map[string]interface{} `json:"cluster"`
+}
+
+// configDumpSection covers the sections of /config_dump the client reads.
+// Envoy 1.13 reports dynamic listeners as dynamic_active_listeners, later
+// versions as dynamic_listeners with an active state.
+type configDumpSection struct {
+	Type                   string                 `json:"@type"`
+	Bootstrap              map[string]interface{} `json:"bootstrap"`
+	StaticListeners        []dumpedListener       `json:"static_listeners"`
+	DynamicActiveListeners []dumpedListener       `json:"dynamic_active_listeners"`
+	DynamicListeners       []struct {
+		ActiveState *dumpedListener `json:"active_state"`
+	} `json:"dynamic_listeners"`
+	StaticClusters        []dumpedCluster `json:"static_clusters"`
+	DynamicActiveClusters []dumpedCluster `json:"dynamic_active_clusters"`
+}
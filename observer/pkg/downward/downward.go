@@ -0,0 +1,115 @@
+package downward
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// Span tag and node metadata keys, following the OpenTelemetry conventions
+const (
+	PodNameKey       = "k8s.pod.name"
+	PodNamespaceKey  = "k8s.namespace.name"
+	NodeNameKey      = "k8s.node.name"
+	LabelPrefix      = "k8s.pod.label."
+	AnnotationPrefix = "k8s.pod.annotation."
+)
+
+// maxLineSize bounds a line of a Downward API file. Annotations can hold
+// whole manifests, which easily exceed the default scanner limit.
+const maxLineSize = 1024 * 1024
+
+// PodInfo holds the pod details exposed through the Kubernetes Downward API,
+// either as environment variables or as files mounted into the container.
+type PodInfo struct {
+	Name        string
+	Namespace   string
+	NodeName    string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Load reads the labels and annotations files. Files that do not exist are
+// ignored so the observer keeps working without a Downward API volume.
+func Load(name, namespace, nodeName, labelsPath, annotationsPath string) (PodInfo, error) {
+	labels, err := ReadFile(labelsPath)
+	if err != nil {
+		return PodInfo{}, err
+	}
+	annotations, err := ReadFile(annotationsPath)
+	if err != nil {
+		return PodInfo{}, err
+	}
+	return PodInfo{
+		Name:        name,
+		Namespace:   namespace,
+		NodeName:    nodeName,
+		Labels:      labels,
+		Annotations: annotations,
+	}, nil
+}
+
+// ReadFile parses a Downward API file where every line is in the form
+// key="value" and values are quoted Go strings.
+func ReadFile(path string) (map[string]string, error) {
+	values := map[string]string{}
+	if path == "" {
+		return values, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, merry.Prependf(err, "could not read downward API file %s", path)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, merry.Errorf("invalid line [%s] in downward API file %s", line, path)
+		}
+		value, err := strconv.Unquote(kv[1])
+		if err != nil {
+			return nil, merry.Errorf("invalid value for [%s] in downward API file %s", kv[0], path)
+		}
+		values[kv[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, merry.Prependf(err, "could not read downward API file %s", path)
+	}
+	return values, nil
+}
+
+// Tags returns the pod name, namespace and node name along with the selected
+// labels and annotations. Empty and missing values are left out.
+func (p PodInfo) Tags(labelKeys []string, annotationKeys []string) map[string]string {
+	tags := map[string]string{}
+	add := func(key, value string) {
+		if value != "" {
+			tags[key] = value
+		}
+	}
+
+	add(PodNameKey, p.Name)
+	add(PodNamespaceKey, p.Namespace)
+	add(NodeNameKey, p.NodeName)
+	for _, key := range labelKeys {
+		add(LabelPrefix+key, p.Labels[key])
+	}
+	for _, key := range annotationKeys {
+		add(AnnotationPrefix+key, p.Annotations[key])
+	}
+	return tags
+}
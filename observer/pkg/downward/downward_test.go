@@ -0,0 +1,91 @@
+package downward
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "labels")
+	require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "downward")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("Should unquote values", func(t *testing.T) {
+		path := writeFile(t, dir, "app=\"web\"\n\nempty=\"\"\nurl=\"http://a=b\"\n")
+
+		// When
+		values, err := ReadFile(path)
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, map[string]string{"app": "web", "empty": "", "url": "http://a=b"}, values)
+	})
+
+	t.Run("Should unescape values", func(t *testing.T) {
+		path := writeFile(t, dir, `config="{\"a\": \"b\"}\n"`+"\n"+`path="C:\\tmp"`+"\n")
+
+		// When
+		values, err := ReadFile(path)
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, "{\"a\": \"b\"}\n", values["config"])
+		assert.Equal(t, `C:\tmp`, values["path"])
+	})
+
+	t.Run("Should read long lines", func(t *testing.T) {
+		long := strings.Repeat("x", 100*1024)
+		path := writeFile(t, dir, "last-applied=\""+long+"\"\napp=\"web\"\n")
+
+		// When
+		values, err := ReadFile(path)
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, long, values["last-applied"])
+		assert.Equal(t, "web", values["app"])
+	})
+
+	t.Run("Should ignore missing files", func(t *testing.T) {
+		// When
+		values, err := ReadFile(filepath.Join(dir, "missing"))
+
+		// Then
+		require.Nil(t, err)
+		assert.Empty(t, values)
+	})
+
+	t.Run("Failing: unquoted value", func(t *testing.T) {
+		path := writeFile(t, dir, "app=web\n")
+
+		// When
+		_, err := ReadFile(path)
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "invalid value for [app]")
+	})
+
+	t.Run("Failing: line without value", func(t *testing.T) {
+		path := writeFile(t, dir, "app\n")
+
+		// When
+		_, err := ReadFile(path)
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "invalid line [app]")
+	})
+}
@@ -0,0 +1,250 @@
+// Package drift compares the config a running Envoy reports through its
+// admin API with the config generated for the current options.
+package drift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ansel1/merry"
+	v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	trace "github.com/envoyproxy/go-control-plane/envoy/config/trace/v2"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/omnition/omnition-observer/observer/pkg/admin"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/xds"
+)
+
+// Kinds of Difference
+const (
+	Missing    = "missing"
+	Unexpected = "unexpected"
+	Changed    = "changed"
+)
+
+// Difference describes a listener, cluster or the tracing config that does
+// not match the generated config. Paths lists the fields that differ.
+type Difference struct {
+	Section string
+	Name    string
+	Kind    string
+	Paths   []string
+}
+
+func (d Difference) String() string {
+	s := d.Section
+	if d.Name != "" {
+		s += " " + d.Name
+	}
+	s += " " + d.Kind
+	if len(d.Paths) > 0 {
+		s += ": " + strings.Join(d.Paths, ", ")
+	}
+	return s
+}
+
+// Compare reports how the live config differs from cfg. Both sides are
+// converted to their proto representation first, so that formatting and
+// defaults do not count as differences. Envoys served by the control plane
+// are compared with the resources it serves.
+func Compare(cfg envoy.Config, live *admin.ConfigDump) ([]Difference, error) {
+	listeners := cfg.StaticResources.Listeners
+	clusters := cfg.StaticResources.Clusters
+	if servedByControlPlane(live.Bootstrap) {
+		bootstrap, resources := xds.Bootstrap(cfg, "")
+		listeners = resources.Listeners
+		clusters = append(append([]envoy.Cluster{}, bootstrap.StaticResources.Clusters...), resources.Clusters...)
+	}
+
+	expectedListeners := map[string]interface{}{}
+	for _, l := range listeners {
+		listener, err := xds.Listener(l)
+		if err != nil {
+			return nil, err
+		}
+		if expectedListeners[l.Name], err = normalize(listener); err != nil {
+			return nil, err
+		}
+	}
+	expectedClusters := map[string]interface{}{}
+	for _, c := range clusters {
+		// The control plane cluster only depends on the socket path
+		if c.Name == xds.ClusterName {
+			continue
+		}
+		cluster, err := xds.Cluster(c)
+		if err != nil {
+			return nil, err
+		}
+		if expectedClusters[c.Name], err = normalize(cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	liveListeners := map[string]interface{}{}
+	for _, l := range live.Listeners {
+		name := fmt.Sprint(l["name"])
+		var err error
+		if liveListeners[name], err = parseLive(l, &v2.Listener{}); err != nil {
+			return nil, merry.Prepend(err, "live listener "+name)
+		}
+	}
+	liveClusters := map[string]interface{}{}
+	for _, c := range live.Clusters {
+		name := fmt.Sprint(c["name"])
+		if name == xds.ClusterName {
+			continue
+		}
+		var err error
+		if liveClusters[name], err = parseLive(c, &v2.Cluster{}); err != nil {
+			return nil, merry.Prepend(err, "live cluster "+name)
+		}
+	}
+
+	differences := compareSection("listener", expectedListeners, liveListeners)
+	differences = append(differences, compareSection("cluster", expectedClusters, liveClusters)...)
+
+	tracing, err := xds.Tracing(cfg.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	expectedTracing, err := normalize(tracing)
+	if err != nil {
+		return nil, err
+	}
+	liveTracing := interface{}(map[string]interface{}{})
+	if section, ok := live.Bootstrap["tracing"].(map[string]interface{}); ok {
+		if liveTracing, err = parseLive(section, &trace.Tracing{}); err != nil {
+			return nil, merry.Prepend(err, "live tracing")
+		}
+	}
+	if paths := diffPaths("", expectedTracing, liveTracing); len(paths) > 0 {
+		differences = append(differences, Difference{Section: "tracing", Kind: Changed, Paths: paths})
+	}
+	return differences, nil
+}
+
+// servedByControlPlane reports whether the live bootstrap loads listeners
+// from the control plane rather than from files or static resources.
+func servedByControlPlane(bootstrap map[string]interface{}) bool {
+	dynamic, _ := bootstrap["dynamic_resources"].(map[string]interface{})
+	lds, _ := dynamic["lds_config"].(map[string]interface{})
+	_, ok := lds["api_config_source"]
+	return ok
+}
+
+func compareSection(section string, expected, live map[string]interface{}) []Difference {
+	names := []string{}
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range live {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	differences := []Difference{}
+	for _, name := range names {
+		e, inExpected := expected[name]
+		l, inLive := live[name]
+		switch {
+		case !inLive:
+			differences = append(differences, Difference{Section: section, Name: name, Kind: Missing})
+		case !inExpected:
+			differences = append(differences, Difference{Section: section, Name: name, Kind: Unexpected})
+		default:
+			if paths := diffPaths("", e, l); len(paths) > 0 {
+				differences = append(differences, Difference{Section: section, Name: name, Kind: Changed, Paths: paths})
+			}
+		}
+	}
+	return differences
+}
+
+// diffPaths returns the paths of the values that differ between a and b.
+// Lists of different length are reported as a whole.
+func diffPaths(path string, a, b interface{}) []string {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, ok := av[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		paths := []string{}
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			paths = append(paths, diffPaths(child, av[key], bv[key])...)
+		}
+		return paths
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			break
+		}
+		paths := []string{}
+		for i := range av {
+			paths = append(paths, diffPaths(fmt.Sprintf("%s[%d]", path, i), av[i], bv[i])...)
+		}
+		return paths
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []string{path}
+}
+
+var marshaler = jsonpb.Marshaler{OrigName: true}
+
+// normalize returns the JSON form of pb decoded into generic values.
+func normalize(pb proto.Message) (interface{}, error) {
+	var buf bytes.Buffer
+	if err := marshaler.Marshal(&buf, pb); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return v, nil
+}
+
+// parseLive normalizes a section of the config dump through pb. Fields
+// newer Envoy versions report are ignored.
+func parseLive(section map[string]interface{}, pb proto.Message) (interface{}, error) {
+	fields := make(map[string]interface{}, len(section))
+	for key, value := range section {
+		// Sections dumped as Any carry their type
+		if key != "@type" {
+			fields[key] = value
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err := unmarshaler.Unmarshal(bytes.NewReader(data), pb); err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return normalize(pb)
+}
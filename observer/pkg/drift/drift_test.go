@@ -0,0 +1,130 @@
+package drift
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/omnition/omnition-observer/observer/pkg/admin"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy/envoytest"
+	"github.com/omnition/omnition-observer/observer/pkg/xds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// dumped returns pb the way the admin API reports it.
+func dumped(t *testing.T, pb proto.Message, typeURL string) map[string]interface{} {
+	var buf bytes.Buffer
+	require.Nil(t, (&jsonpb.Marshaler{OrigName: true}).Marshal(&buf, pb))
+	section := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal(buf.Bytes(), &section))
+	section["@type"] = typeURL
+	return section
+}
+
+// liveDump returns the config dump of an Envoy running bootstrap with the
+// listeners and clusters.
+func liveDump(t *testing.T, bootstrap envoy.Config, listeners []envoy.Listener, clusters []envoy.Cluster) *admin.ConfigDump {
+	serialized, err := yaml.Marshal(&bootstrap)
+	require.Nil(t, err)
+	dump := &admin.ConfigDump{}
+	require.Nil(t, yaml3.Unmarshal(serialized, &dump.Bootstrap))
+	tracing, err := xds.Tracing(bootstrap.Tracing)
+	require.Nil(t, err)
+	dump.Bootstrap["tracing"] = dumped(t, tracing, "")
+	delete(dump.Bootstrap["tracing"].(map[string]interface{}), "@type")
+
+	for _, l := range listeners {
+		listener, err := xds.Listener(l)
+		require.Nil(t, err)
+		dump.Listeners = append(dump.Listeners, dumped(t, listener, envoy.ListenerType))
+	}
+	for _, c := range clusters {
+		cluster, err := xds.Cluster(c)
+		require.Nil(t, err)
+		dump.Clusters = append(dump.Clusters, dumped(t, cluster, envoy.ClusterType))
+	}
+	return dump
+}
+
+func find(sections []map[string]interface{}, name string) map[string]interface{} {
+	for _, section := range sections {
+		if section["name"] == name {
+			return section
+		}
+	}
+	return nil
+}
+
+func TestCompare(t *testing.T) {
+	t.Run("Should not report matching configs", func(t *testing.T) {
+		cfg := envoytest.NewConfig(t, false, 100)
+		live := liveDump(t, cfg, cfg.StaticResources.Listeners, cfg.StaticResources.Clusters)
+		find(live.Clusters, envoy.TracingClusterName)["connect_timeout"] = "1.000s"
+
+		// When
+		differences, err := Compare(cfg, live)
+
+		// Then
+		require.Nil(t, err)
+		assert.Empty(t, differences, "Formatting should not count as drift")
+	})
+
+	t.Run("Should report changed, missing and unexpected resources", func(t *testing.T) {
+		running := envoytest.NewConfig(t, false, 100)
+		stale := envoy.Cluster{Name: "stale_cluster", ConnectTimeout: envoy.Duration(time.Second), Type: "STATIC", LBPolicy: "ROUND_ROBIN"}
+		clusters := append([]envoy.Cluster{stale}, running.StaticResources.Clusters[1:]...)
+		live := liveDump(t, running, running.StaticResources.Listeners, clusters)
+
+		// When
+		differences, err := Compare(envoytest.NewConfig(t, false, 10), live)
+
+		// Then
+		require.Nil(t, err)
+		reported := []string{}
+		for _, d := range differences {
+			reported = append(reported, d.String())
+		}
+		assert.Contains(t, reported, "cluster stale_cluster unexpected")
+		assert.Contains(t, reported, "cluster "+running.StaticResources.Clusters[0].Name+" missing")
+		assert.Equal(t, "listener", differences[0].Section)
+		assert.Equal(t, "egress_listener", differences[0].Name)
+		assert.Equal(t, Changed, differences[0].Kind)
+		assert.Contains(t, differences[0].Paths, "filter_chains[0].filters[0].typed_config.tracing.random_sampling.value")
+	})
+
+	t.Run("Should report tracing changes", func(t *testing.T) {
+		cfg := envoytest.NewConfig(t, false, 100)
+		live := liveDump(t, cfg, cfg.StaticResources.Listeners, cfg.StaticResources.Clusters)
+		typed := live.Bootstrap["tracing"].(map[string]interface{})["http"].(map[string]interface{})["typed_config"].(map[string]interface{})
+		typed["collector_endpoint"] = "/api/v1/spans"
+
+		// When
+		differences, err := Compare(cfg, live)
+
+		// Then
+		require.Nil(t, err)
+		require.Len(t, differences, 1)
+		assert.Equal(t, "tracing changed: http.typed_config.collector_endpoint", differences[0].String())
+	})
+
+	t.Run("Should compare control plane resources", func(t *testing.T) {
+		cfg := envoytest.NewConfig(t, true, 100)
+		bootstrap, resources := xds.Bootstrap(cfg, "/tmp/xds.sock")
+		clusters := append(append([]envoy.Cluster{}, bootstrap.StaticResources.Clusters...), resources.Clusters...)
+		live := liveDump(t, bootstrap, resources.Listeners, clusters)
+
+		// When
+		differences, err := Compare(cfg, live)
+
+		// Then
+		require.Nil(t, err)
+		assert.Empty(t, differences, "Certificates served over SDS should match")
+	})
+}
@@ -0,0 +1,81 @@
+package envoy
+
+// Access log fields shared by HTTP and TCP filter chains
+var accessLogCommonFields = []accessLogField{
+	{"start_time", "%START_TIME%"},
+	{"direction", ""},
+	{"downstream_remote_address", "%DOWNSTREAM_REMOTE_ADDRESS%"},
+	{"upstream_host", "%UPSTREAM_HOST%"},
+	{"upstream_cluster", "%UPSTREAM_CLUSTER%"},
+	{"response_flags", "%RESPONSE_FLAGS%"},
+	{"bytes_received", "%BYTES_RECEIVED%"},
+	{"bytes_sent", "%BYTES_SENT%"},
+	{"duration", "%DURATION%"},
+}
+
+// Access log fields only available on HTTP filter chains
+var accessLogHTTPFields = []accessLogField{
+	{"method", "%REQ(:METHOD)%"},
+	{"path", "%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%"},
+	{"protocol", "%PROTOCOL%"},
+	{"authority", "%REQ(:AUTHORITY)%"},
+	{"response_code", "%RESPONSE_CODE%"},
+	{"upstream_service_time", "%RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)%"},
+	{"request_id", "%REQ(X-REQUEST-ID)%"},
+	{"user_agent", "%REQ(USER-AGENT)%"},
+	{"x_forwarded_for", "%REQ(X-FORWARDED-FOR)%"},
+}
+
+type accessLogField struct {
+	Name  string
+	Value string
+}
+
+type AccessLogConfig struct {
+	ConfigType string            `yaml:"@type"`
+	Path       string            `yaml:"path"`
+	Format     string            `yaml:"format,omitempty"`
+	JSONFormat map[string]string `yaml:"json_format,omitempty"`
+}
+
+type RuntimeUInt32 struct {
+	DefaultValue int    `yaml:"default_value"`
+	RuntimeKey   string `yaml:"runtime_key"`
+}
+
+type ComparisonFilter struct {
+	Op    string
+	Value RuntimeUInt32
+}
+
+type StatusCodeFilter struct {
+	Comparison ComparisonFilter
+}
+
+type FractionalPercent struct {
+	Numerator   int
+	Denominator string
+}
+
+type RuntimeFilter struct {
+	RuntimeKey     string            `yaml:"runtime_key"`
+	PercentSampled FractionalPercent `yaml:"percent_sampled"`
+}
+
+type AccessLogFilterList struct {
+	Filters []AccessLogFilter
+}
+
+type AccessLogFilter struct {
+	AndFilter          *AccessLogFilterList `yaml:"and_filter,omitempty"`
+	OrFilter           *AccessLogFilterList `yaml:"or_filter,omitempty"`
+	StatusCodeFilter   *StatusCodeFilter    `yaml:"status_code_filter,omitempty"`
+	ResponseFlagFilter *struct{}            `yaml:"response_flag_filter,omitempty"`
+	RuntimeFilter      *RuntimeFilter       `yaml:"runtime_filter,omitempty"`
+}
+
+type AccessLog struct {
+	Name        string
+	Filter      *AccessLogFilter `yaml:"filter,omitempty"`
+	TypedConfig AccessLogConfig  `yaml:"typed_config"`
+}
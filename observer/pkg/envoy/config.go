@@ -2,56 +2,80 @@ package envoy
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/omnition/omnition-observer/observer/pkg/options"
 )
 
+// downstreamLabel names the clusters that forward requests with the protocol
+// of the client.
+const downstreamLabel = "downstream"
+
 func newFilterChain(
 	direction TrafficDirection,
 	protocol Protocol,
 	httpsRedirect bool,
+	destinationPort int,
 	opts options.Options,
 ) FilterChain {
+	drName := direction.Label()
+	protoLabel := protocol.Label()
 
-	drName := "ingress"
-	if direction == EGRESS {
-		drName = "egress"
-	}
-
-	protoLabel := ""
 	alpnProtocol := ""
 	filterMatchProto := "http/1.1"
 	switch protocol {
 	case TCP:
 		return FilterChain{
+			FilterChainMatch: FilterChainMatch{
+				DestinationPort: destinationPort,
+			},
 			Filters: []Filter{
 				Filter{
 					Name: "envoy.tcp_proxy",
 					TypedConfig: FilterConfig{
-						ConfigType: "type.googleapis.com/envoy.config.filter.network.tcp_proxy.v2.TcpProxy",
-						StatPrefix: drName + "_tcp",
-						Cluster:    "tcp_" + drName + "_cluster",
+						ConfigType:  "type.googleapis.com/envoy.config.filter.network.tcp_proxy.v2.TcpProxy",
+						StatPrefix:  drName + "_" + protoLabel,
+						Cluster:     protoLabel + "_" + drName + "_cluster",
+						IdleTimeout: newIdleTimeout(opts.ClusterConnection),
+						AccessLog:   newAccessLogs(direction, protocol, opts),
 					},
 				},
 			},
 		}
 	case HTTP1:
-		protoLabel = "h1"
 		alpnProtocol = "http/1.1"
 		filterMatchProto = "http/1.1"
 	case HTTP2:
-		protoLabel = "h2"
 		alpnProtocol = "http/2.0"
 		filterMatchProto = "h2"
 	}
 
 	label := protoLabel + "_" + drName
+	clusterName := upstreamProtocolLabel(protocol, destinationPort, opts) + "_" + drName + "_cluster"
+
+	routes := []VirtualHostRoute{}
+	if opts.TLSEnabled && httpsRedirect {
+		// Setup HTTP > HTTPS redirect
+		routes = append(routes, VirtualHostRoute{
+			Match: VirtualHostRouteMatch{Prefix: "/"},
+			Redirect: VirtualHostRouteRedirect{
+				PathRedirect:  "/",
+				HTTPSRedirect: true,
+			},
+		})
+	} else {
+		// Setup actual routes
+		routes = newVirtualHostRoutes(direction, clusterName, opts)
+	}
 
 	chain := FilterChain{
 		FilterChainMatch: FilterChainMatch{
-			ApplicationProtocols: filterMatchProto,
+			DestinationPort:      destinationPort,
+			ApplicationProtocols: []string{filterMatchProto},
 		},
 
 		Filters: []Filter{
@@ -60,27 +84,23 @@ func newFilterChain(
 				TypedConfig: FilterConfig{
 					ConfigType:        "type.googleapis.com/envoy.config.filter.network.http_connection_manager.v2.HttpConnectionManager",
 					StatPrefix:        label,
-					CodecType:         "auto",
+					CodecType:         "AUTO",
 					GenerateRequestID: true,
 					UseRemoteAddress:  true,
 					TrustedHopsCount:  opts.TrustedHopsCount,
+					DrainTimeout:      newDrainTimeout(opts.Drain),
+					AccessLog:         newAccessLogs(direction, protocol, opts),
+					// The sampling percentages are the defaults of the
+					// RuntimeTracing* keys
 					Tracing: FilterConfigTracing{
-						CustomTags:      opts.TracingTagHeaders,
+						CustomTags:      newCustomTags(opts),
+						ClientSampling:  Value{100},
+						RandomSampling:  Value{opts.TracingSampling},
 						OverallSampling: Value{100},
 					},
 					RouteConfig: RouteConfig{
-						Name: label + "_route",
-						VirtualHosts: []VirtualHost{
-							VirtualHost{
-								Name:    label + "_vhost",
-								Domains: []string{"*"},
-								Routes: []VirtualHostRoute{
-									VirtualHostRoute{
-										Match: VirtualHostRouteMatch{"/"},
-									},
-								},
-							},
-						},
+						Name:         label + "_route",
+						VirtualHosts: newVirtualHosts(direction, label, routes, httpsRedirect, opts),
 					},
 					HTTPFilters: []HTTPFilter{
 						HTTPFilter{Name: "envoy.grpc_http1_bridge"},
@@ -91,12 +111,16 @@ func newFilterChain(
 		},
 	}
 
+	if protocol == HTTP1 {
+		chain.Filters[0].TypedConfig.HTTPProtocolOptions = newDownstreamHTTPProtocolOptions(opts.HTTP1)
+	}
+
 	if opts.TLSEnabled {
 		// Setup TLS certificates
 		if direction == INGRESS && !httpsRedirect {
 			chain.TLSContext = &TLSContext{
 				CommonTLSContext{
-					ALPNProtocols: alpnProtocol,
+					ALPNProtocols: []string{alpnProtocol},
 					TLSCertificates: []TLSCertificate{
 						TLSCertificate{
 							CertificateChain: DataSource{
@@ -111,37 +135,318 @@ func newFilterChain(
 			}
 		}
 
-		if httpsRedirect {
-			// Setup HTTP > HTTPS redirect
-			chain.Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Redirect = VirtualHostRouteRedirect{
-				PathRedirect:  "/",
-				HTTPSRedirect: true,
+		if !httpsRedirect && direction == INGRESS {
+			chain.FilterChainMatch.TransportProtocol = "tls"
+		}
+	}
+
+	return chain
+}
+
+// newDownstreamHTTPProtocolOptions returns the HTTP/1 codec options for
+// connections accepted by the proxy or nil when Envoy's defaults apply.
+func newDownstreamHTTPProtocolOptions(settings options.HTTP1Settings) *HTTPProtocolOptions {
+	o := HTTPProtocolOptions{
+		AllowAbsoluteURL:     settings.AllowAbsoluteURL,
+		AcceptHTTP10:         settings.AcceptHTTP10,
+		DefaultHostForHTTP10: settings.DefaultHostForHTTP10,
+		HeaderKeyFormat:      newHeaderKeyFormat(settings),
+	}
+	if o == (HTTPProtocolOptions{}) {
+		return nil
+	}
+	return &o
+}
+
+func newHeaderKeyFormat(settings options.HTTP1Settings) *HeaderKeyFormat {
+	if settings.HeaderKeyFormat == options.HeaderKeyFormatProperCase {
+		return &HeaderKeyFormat{ProperCaseWords: &struct{}{}}
+	}
+	return nil
+}
+
+// newAccessLogs returns the file access log for a filter chain or nil when
+// access logging is disabled.
+func newAccessLogs(direction TrafficDirection, protocol Protocol, opts options.Options) []AccessLog {
+	if !opts.AccessLog.Enabled() {
+		return nil
+	}
+
+	fields := []accessLogField{}
+	for _, f := range accessLogCommonFields {
+		if f.Name == "direction" {
+			f.Value = direction.Label()
+		}
+		fields = append(fields, f)
+	}
+	if protocol != TCP {
+		fields = append(fields, accessLogHTTPFields...)
+		fields = append(fields, accessLogField{"trace_id", traceIDFormat(opts)})
+	}
+
+	config := AccessLogConfig{
+		ConfigType: "type.googleapis.com/envoy.config.accesslog.v2.FileAccessLog",
+		Path:       opts.AccessLog.Path,
+	}
+	if opts.AccessLog.Format == options.AccessLogFormatJSON {
+		config.JSONFormat = map[string]string{}
+		for _, f := range fields {
+			config.JSONFormat[f.Name] = f.Value
+		}
+	} else {
+		pairs := []string{}
+		for _, f := range fields {
+			pairs = append(pairs, f.Name+"=\""+f.Value+"\"")
+		}
+		config.Format = strings.Join(pairs, " ") + "\n"
+	}
+
+	return []AccessLog{
+		AccessLog{
+			Name:        "envoy.file_access_log",
+			Filter:      newAccessLogFilter(protocol, opts.AccessLog),
+			TypedConfig: config,
+		},
+	}
+}
+
+// traceIDFormat returns the access log command extracting the trace ID from
+// the headers propagated by the configured tracer. Jaeger propagates the
+// whole span context in one header, which Envoy can only log as a whole, so
+// the trace ID is its first field.
+func traceIDFormat(opts options.Options) string {
+	if strings.EqualFold(opts.TracingDriver, JAEGER) {
+		return "%REQ(UBER-TRACE-ID)%"
+	}
+	return "%REQ(X-B3-TRACEID)%"
+}
+
+func newAccessLogFilter(protocol Protocol, accessLog options.AccessLog) *AccessLogFilter {
+	filters := []AccessLogFilter{}
+
+	if accessLog.Filter == options.AccessLogFilterErrors {
+		// Failed connections are flagged by Envoy. HTTP requests are also
+		// considered failed when the response is a 5xx.
+		errors := AccessLogFilter{ResponseFlagFilter: &struct{}{}}
+		if protocol != TCP {
+			errors = AccessLogFilter{
+				OrFilter: &AccessLogFilterList{
+					Filters: []AccessLogFilter{
+						AccessLogFilter{
+							StatusCodeFilter: &StatusCodeFilter{
+								ComparisonFilter{
+									Op: "GE",
+									Value: RuntimeUInt32{
+										DefaultValue: 500,
+										RuntimeKey:   RuntimeAccessLogErrorStatusCode,
+									},
+								},
+							},
+						},
+						errors,
+					},
+				},
 			}
-		} else {
-			// Setup actual route
-			if direction == INGRESS {
-				chain.FilterChainMatch.TransportProtocol = "tls"
+		}
+		filters = append(filters, errors)
+	}
+
+	if accessLog.SamplePercent < 100 {
+		filters = append(filters, AccessLogFilter{
+			RuntimeFilter: &RuntimeFilter{
+				RuntimeKey: RuntimeAccessLogSamplePercent,
+				PercentSampled: FractionalPercent{
+					Numerator:   accessLog.SamplePercent,
+					Denominator: "HUNDRED",
+				},
+			},
+		})
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return &filters[0]
+	}
+	return &AccessLogFilter{AndFilter: &AccessLogFilterList{Filters: filters}}
+}
+
+// upstreamProtocolLabel returns the label of the cluster HTTP requests are
+// forwarded to. Requests keep their protocol unless the destination port has
+// been configured to use a specific upstream protocol. With the downstream
+// upstream protocol, requests of either protocol go to the downstream
+// cluster, which picks the protocol per request.
+func upstreamProtocolLabel(protocol Protocol, destinationPort int, opts options.Options) string {
+	switch opts.HTTP2.PortUpstreamProtocols[destinationPort] {
+	case options.UpstreamHTTP1:
+		return Protocol(HTTP1).Label()
+	case options.UpstreamHTTP2:
+		return Protocol(HTTP2).Label()
+	}
+	if protocol != TCP && opts.HTTP2.UpstreamProtocol == options.UpstreamDownstream {
+		return downstreamLabel
+	}
+	return protocol.Label()
+}
+
+// newCustomTags tags spans with the configured request headers and with the
+// fixed tags sorted by name.
+func newCustomTags(opts options.Options) []CustomTag {
+	var tags []CustomTag
+	for _, header := range opts.TracingTagHeaders {
+		tags = append(tags, CustomTag{
+			Tag:           header,
+			RequestHeader: &CustomTagHeader{Name: header},
+		})
+	}
+
+	names := []string{}
+	for name := range opts.TracingTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tags = append(tags, CustomTag{
+			Tag:     name,
+			Literal: &CustomTagLiteral{Value: opts.TracingTags[name]},
+		})
+	}
+	return tags
+}
+
+// newVirtualHosts returns the catch-all virtual host for a chain and, when the
+// egress retry policy is scoped to specific destinations, an additional
+// virtual host matching only those destinations.
+func newVirtualHosts(
+	direction TrafficDirection,
+	label string,
+	routes []VirtualHostRoute,
+	httpsRedirect bool,
+	opts options.Options,
+) []VirtualHost {
+	vhosts := []VirtualHost{
+		VirtualHost{
+			Name:    label + "_vhost",
+			Domains: []string{"*"},
+			Routes:  routes,
+		},
+	}
+
+	policy := opts.EgressRetryPolicy
+	if direction != EGRESS || httpsRedirect || !policy.Enabled() {
+		return vhosts
+	}
+
+	if !policy.Scoped() {
+		vhosts[0].RetryPolicy = newRetryPolicy(policy)
+		return vhosts
+	}
+
+	return append(vhosts, VirtualHost{
+		Name:        label + "_retry_vhost",
+		Domains:     retryPolicyDomains(policy),
+		Routes:      routes,
+		RetryPolicy: newRetryPolicy(policy),
+	})
+}
+
+func newRetryPolicy(policy options.RetryPolicy) *RetryPolicy {
+	p := &RetryPolicy{
+		RetryOn:       strings.Join(policy.RetryOn, ","),
+		NumRetries:    policy.NumRetries,
+		PerTryTimeout: Duration(policy.PerTryTimeout),
+	}
+	if policy.BackOffBase > 0 {
+		p.RetryBackOff = &RetryBackOff{
+			BaseInterval: Duration(policy.BackOffBase),
+			MaxInterval:  Duration(policy.BackOffMax),
+		}
+	}
+	return p
+}
+
+// retryPolicyDomains translates the destinations a retry policy is scoped to
+// into virtual host domains. Envoy matches domains against the Host/:authority
+// header so ports are matched as ":<port>" suffixes.
+func retryPolicyDomains(policy options.RetryPolicy) []string {
+	domains := []string{}
+	switch {
+	case len(policy.Hosts) > 0 && len(policy.Ports) > 0:
+		for _, host := range policy.Hosts {
+			for _, port := range policy.Ports {
+				domains = append(domains, host+":"+strconv.Itoa(port))
 			}
-			chain.Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route = newVirtualHostRouteCluster(
-				direction, protoLabel+"_"+drName+"_cluster", opts,
-			)
 		}
-	} else {
-		// TLS not configured. Always handle route as is
-		chain.Filters[0].TypedConfig.RouteConfig.VirtualHosts[0].Routes[0].Route = newVirtualHostRouteCluster(
-			direction, protoLabel+"_"+drName+"_cluster", opts,
-		)
+	case len(policy.Hosts) > 0:
+		for _, host := range policy.Hosts {
+			domains = append(domains, host, host+":*")
+		}
+	default:
+		for _, port := range policy.Ports {
+			domains = append(domains, "*:"+strconv.Itoa(port))
+		}
 	}
+	return domains
+}
 
-	return chain
+// newVirtualHostRoutes returns a route for every timeout rule of the given
+// direction followed by the catch-all route using the default timeout.
+func newVirtualHostRoutes(direction TrafficDirection, cluster string, opts options.Options) []VirtualHostRoute {
+	timeout := opts.TimeoutDuration
+	rules := opts.IngressTimeoutRules
+	if direction == EGRESS {
+		timeout = opts.EgressTimeoutDuration
+		rules = opts.EgressTimeoutRules
+	}
+
+	routes := []VirtualHostRoute{}
+	for _, rule := range rules {
+		prefix := rule.PathPrefix
+		if prefix == "" {
+			prefix = "/"
+		}
+		routes = append(routes, VirtualHostRoute{
+			Match: VirtualHostRouteMatch{
+				Prefix:  prefix,
+				Headers: newAuthorityMatchers(rule.Host, rule.Port),
+			},
+			Route: newVirtualHostRouteCluster(cluster, rule.Timeout),
+		})
+	}
+
+	return append(routes, VirtualHostRoute{
+		Match: VirtualHostRouteMatch{Prefix: "/"},
+		Route: newVirtualHostRouteCluster(cluster, timeout),
+	})
 }
 
-func newVirtualHostRouteCluster(direction TrafficDirection, name string, opts options.Options) VirtualHostRouteCluster {
-	c := VirtualHostRouteCluster{Cluster: name}
-	if direction == INGRESS {
-		c.Timeout = &opts.TimeoutDuration
+// newAuthorityMatchers matches requests on the host and port found in the
+// :authority (Host) header.
+func newAuthorityMatchers(host string, port int) []HeaderMatcher {
+	switch {
+	case host != "" && port != 0:
+		return []HeaderMatcher{
+			HeaderMatcher{Name: ":authority", ExactMatch: host + ":" + strconv.Itoa(port)},
+		}
+	case host != "":
+		return []HeaderMatcher{
+			HeaderMatcher{
+				Name:           ":authority",
+				SafeRegexMatch: &RegexMatcher{Regex: "^" + regexp.QuoteMeta(host) + "(:[0-9]+)?$"},
+			},
+		}
+	case port != 0:
+		return []HeaderMatcher{
+			HeaderMatcher{Name: ":authority", SuffixMatch: ":" + strconv.Itoa(port)},
+		}
 	}
-	return c
+	return nil
+}
+
+func newVirtualHostRouteCluster(name string, timeout time.Duration) VirtualHostRouteCluster {
+	d := Duration(timeout)
+	return VirtualHostRouteCluster{Cluster: name, Timeout: &d}
 }
 
 func newListener(direction TrafficDirection, opts options.Options) Listener {
@@ -162,6 +467,7 @@ func newListener(direction TrafficDirection, opts options.Options) Listener {
 			},
 		},
 		Transparent: true,
+		DrainType:   newDrainType(opts.Drain),
 		ListenerFilters: []ListenerFilter{
 			ListenerFilter{"envoy.listener.original_dst"},
 			ListenerFilter{"envoy.listener.http_inspector"},
@@ -169,59 +475,92 @@ func newListener(direction TrafficDirection, opts options.Options) Listener {
 		},
 	}
 
+	chains := newFilterChains(direction, 0, opts)
+
+	// Envoy only considers chains matching a destination port when one
+	// exists, so every port with its own upstream protocol gets a full set.
+	ports := []int{}
+	for port := range opts.HTTP2.PortUpstreamProtocols {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		chains = append(chains, newFilterChains(direction, port, opts)...)
+	}
+
+	listener.FilterChains = chains
+
+	return listener
+}
+
+func newFilterChains(direction TrafficDirection, destinationPort int, opts options.Options) []FilterChain {
 	chains := []FilterChain{}
 
 	// HTTP1 Chain
-	chains = append(chains, newFilterChain(direction, HTTP1, false, opts))
+	chains = append(chains, newFilterChain(direction, HTTP1, false, destinationPort, opts))
 	// HTTP2 Chain
-	chains = append(chains, newFilterChain(direction, HTTP2, false, opts))
+	chains = append(chains, newFilterChain(direction, HTTP2, false, destinationPort, opts))
 
 	if direction == INGRESS && opts.TLSEnabled {
 		// HTTP > HTTPS redirect for incoming traffic
-		chains = append(chains, newFilterChain(direction, HTTP1, true, opts))
-		chains = append(chains, newFilterChain(direction, HTTP2, true, opts))
+		chains = append(chains, newFilterChain(direction, HTTP1, true, destinationPort, opts))
+		chains = append(chains, newFilterChain(direction, HTTP2, true, destinationPort, opts))
 	}
 
-	listener.FilterChains = append(chains, newFilterChain(direction, TCP, false, opts))
-
-	return listener
+	return append(chains, newFilterChain(direction, TCP, false, destinationPort, opts))
 }
 
 func newCluster(direction TrafficDirection, protocol Protocol, opts options.Options) Cluster {
-	drName := "ingress"
-	if direction == EGRESS {
-		drName = "egress"
-	}
-
-	alpnProtocol := ""
-	protoLabel := ""
+	var alpnProtocols []string
 	switch protocol {
 	case HTTP1:
-		alpnProtocol = "http/1.1"
-		protoLabel = "h1"
+		alpnProtocols = []string{"http/1.1"}
 	case HTTP2:
-		alpnProtocol = "http/2.0"
-		protoLabel = "h2"
-	case TCP:
-		protoLabel = "tcp"
+		alpnProtocols = []string{"http/2.0"}
+	}
+
+	circuitBreakers := opts.IngressCircuitBreakers
+	outlierDetection := opts.IngressOutlierDetection
+	if direction == EGRESS {
+		circuitBreakers = opts.EgressCircuitBreakers
+		outlierDetection = opts.EgressOutlierDetection
 	}
 
 	c := Cluster{
-		Name:           protoLabel + "_" + drName + "_cluster",
-		ConnectTimeout: "0.5s",
-		Type:           "ORIGINAL_DST",
-		LBPolicy:       "CLUSTER_PROVIDED",
+		Name:            protocol.Label() + "_" + direction.Label() + "_cluster",
+		ConnectTimeout:  Duration(opts.ClusterConnection.ConnectTimeout),
+		Type:            "ORIGINAL_DST",
+		LBPolicy:        "CLUSTER_PROVIDED",
+		CleanupInterval: Duration(opts.ClusterConnection.CleanupInterval),
+		CircuitBreakers: newCircuitBreakers(circuitBreakers),
+	}
+	applyConnectionSettings(&c, protocol, opts.ClusterConnection)
+	if outlierDetection.Enabled() {
+		c.OutlierDetection = &OutlierDetection{
+			Consecutive5xx:     outlierDetection.Consecutive5xx,
+			Interval:           Duration(outlierDetection.Interval),
+			BaseEjectionTime:   Duration(outlierDetection.BaseEjectionTime),
+			MaxEjectionPercent: outlierDetection.MaxEjectionPercent,
+		}
+	}
+	if protocol == HTTP1 {
+		// Keep header casing consistent with what downstream clients see
+		if format := newHeaderKeyFormat(opts.HTTP1); format != nil {
+			c.HTTPProtocolOptions = &HTTPProtocolOptions{HeaderKeyFormat: format}
+		}
 	}
 	if protocol == HTTP2 {
 		c.HTTP2ProtocolOptions = HTTP2ProtocolOptions{
-			MaxConcurrentStreams: 2147483647,
+			MaxConcurrentStreams:        opts.HTTP2.MaxConcurrentStreams,
+			InitialStreamWindowSize:     opts.HTTP2.InitialStreamWindowSize,
+			InitialConnectionWindowSize: opts.HTTP2.InitialConnectionWindowSize,
 		}
 	}
 
 	if direction == EGRESS && opts.TLSEnabled && opts.TLSCACert != "" {
 		c.TLSContext = TLSContext{
 			CommonTLSContext{
-				ALPNProtocols: alpnProtocol,
+				ALPNProtocols: alpnProtocols,
 				ValidationContext: ValidationContext{
 					DataSource{
 						InlineString: opts.TLSCACert,
@@ -234,23 +573,84 @@ func newCluster(direction TrafficDirection, protocol Protocol, opts options.Opti
 	return c
 }
 
+// applyConnectionSettings sets the idle timeout and TCP keepalive options.
+// TCP clusters have no HTTP protocol options so their idle timeout is set on
+// the tcp_proxy filter instead.
+func applyConnectionSettings(c *Cluster, protocol Protocol, settings options.ConnectionSettings) {
+	if settings.IdleTimeout > 0 && protocol != TCP {
+		c.CommonHTTPProtocolOptions = &CommonHTTPProtocolOptions{
+			IdleTimeout: Duration(settings.IdleTimeout),
+		}
+	}
+	if settings.KeepaliveEnabled() {
+		c.UpstreamConnectionOptions = &UpstreamConnectionOptions{
+			TCPKeepalive{
+				KeepaliveProbes:   settings.KeepaliveProbes,
+				KeepaliveTime:     int(settings.KeepaliveTime / time.Second),
+				KeepaliveInterval: int(settings.KeepaliveInterval / time.Second),
+			},
+		}
+	}
+}
+
+// newDrainType returns the listener drain type, leaving Envoy's default in
+// place unless listeners should keep serving after health checks fail.
+func newDrainType(settings options.DrainSettings) string {
+	if settings.Type == options.DrainTypeModifyOnly {
+		return "MODIFY_ONLY"
+	}
+	return ""
+}
+
+func newDrainTimeout(settings options.DrainSettings) *Duration {
+	if settings.Timeout <= 0 {
+		return nil
+	}
+	d := Duration(settings.Timeout)
+	return &d
+}
+
+func newIdleTimeout(settings options.ConnectionSettings) *Duration {
+	if settings.IdleTimeout <= 0 {
+		return nil
+	}
+	d := Duration(settings.IdleTimeout)
+	return &d
+}
+
+func newCircuitBreakers(cb options.CircuitBreakers) *CircuitBreakers {
+	return &CircuitBreakers{
+		Thresholds: []CircuitBreakerThresholds{
+			CircuitBreakerThresholds{
+				Priority:           "DEFAULT",
+				MaxConnections:     cb.MaxConnections,
+				MaxPendingRequests: cb.MaxPendingRequests,
+				MaxRequests:        cb.MaxRequests,
+				MaxRetries:         cb.MaxRetries,
+			},
+		},
+	}
+}
+
 func newTracingClusterIfRequired(opts options.Options) *Cluster {
 	if opts.TracingDriver == ZIPKIN {
-		return &Cluster{
-			Name:            "tracing_zipkin_cluster",
-			ConnectTimeout:  "1s",
-			Type:            "strict_dns",
-			LBPolicy:        "round_robin",
+		c := &Cluster{
+			Name:            TracingClusterName,
+			ConnectTimeout:  Duration(opts.ClusterConnection.ConnectTimeout),
+			Type:            "STRICT_DNS",
+			LBPolicy:        "ROUND_ROBIN",
 			DnsLookupFamily: "V4_ONLY",
 			Hosts: []ClusterHost{
 				ClusterHost{
-					SocketAddress{
+					SocketAddress: &SocketAddress{
 						Address:   opts.TracingHost,
 						PortValue: opts.TracingPort,
 					},
 				},
 			},
 		}
+		applyConnectionSettings(c, HTTP1, opts.ClusterConnection)
+		return c
 	}
 	return nil
 }
@@ -258,10 +658,10 @@ func newTracingClusterIfRequired(opts options.Options) *Cluster {
 func newTracingConfig(opts options.Options) (*Tracing, error) {
 	if strings.EqualFold(opts.TracingDriver, ZIPKIN) {
 		return newZipkinTracingConfig(), nil
-	} else if strings.EqualFold(opts.TracingDriver, JEAGER) {
-		return newJeagerTracingConfig(opts), nil
+	} else if strings.EqualFold(opts.TracingDriver, JAEGER) {
+		return newJaegerTracingConfig(opts), nil
 	}
-	return nil, fmt.Errorf("invalid tracing driver [%s]. Supported values are: %s, %s", opts.TracingDriver, ZIPKIN, JEAGER)
+	return nil, fmt.Errorf("invalid tracing driver [%s]. Supported values are: %s, %s", opts.TracingDriver, ZIPKIN, JAEGER)
 }
 
 func newZipkinTracingConfig() *Tracing {
@@ -270,7 +670,7 @@ func newZipkinTracingConfig() *Tracing {
 			Name: "envoy.zipkin",
 			Config: TracingZipkinConfig{
 				ConfigType:               "type.googleapis.com/envoy.config.trace.v2.ZipkinConfig",
-				CollectorCluster:         "tracing_zipkin_cluster",
+				CollectorCluster:         TracingClusterName,
 				CollectorEndpoint:        "/api/v2/spans",
 				CollectorEndpointVersion: "HTTP_JSON",
 			},
@@ -278,20 +678,20 @@ func newZipkinTracingConfig() *Tracing {
 	}
 }
 
-func newJeagerTracingConfig(opts options.Options) *Tracing {
+func newJaegerTracingConfig(opts options.Options) *Tracing {
 	return &Tracing{
 		Http: TracingHTTP{
 			Name: "envoy.dynamic.ot",
-			Config: TracingJeagerConfig{
+			Config: TracingJaegerConfig{
 				ConfigType: "type.googleapis.com/envoy.config.trace.v2.DynamicOtConfig",
 				Library:    "/usr/local/lib/libjaegertracing_plugin.so",
-				JeagerConfig: JeagerConfig{
-					ServiceName: "proxy",
-					Sampler: JeagerConfigSampler{
+				JaegerConfig: JaegerConfig{
+					ServiceName: opts.ServiceName,
+					Sampler: JaegerConfigSampler{
 						SamplerType: "const",
 						Param:       1,
 					},
-					Reporter: JeagerConfigReporter{
+					Reporter: JaegerConfigReporter{
 						CollectorEndpoint: "http://" + opts.TracingHost + ":" + strconv.Itoa(opts.TracingPort) + "/api/traces",
 					},
 					Tags: strings.Join(opts.TracingTagHeaders, ","),
@@ -301,6 +701,20 @@ func newJeagerTracingConfig(opts options.Options) *Tracing {
 	}
 }
 
+// newDownstreamCluster returns the cluster that forwards each request with
+// the protocol the client used. HTTP/1 requests keep the configured header
+// casing and TLS connections negotiate the protocol instead of forcing h2.
+func newDownstreamCluster(direction TrafficDirection, opts options.Options) Cluster {
+	c := newCluster(direction, HTTP2, opts)
+	c.Name = downstreamLabel + "_" + direction.Label() + "_cluster"
+	c.ProtocolSelection = "USE_DOWNSTREAM_PROTOCOL"
+	if format := newHeaderKeyFormat(opts.HTTP1); format != nil {
+		c.HTTPProtocolOptions = &HTTPProtocolOptions{HeaderKeyFormat: format}
+	}
+	c.TLSContext.CommonTLSContext.ALPNProtocols = nil
+	return c
+}
+
 func buildClusterConfigurations(opts options.Options) []Cluster {
 	clusters := []Cluster{
 		newCluster(INGRESS, HTTP1, opts),
@@ -311,20 +725,45 @@ func buildClusterConfigurations(opts options.Options) []Cluster {
 		newCluster(EGRESS, TCP, opts),
 	}
 
+	if opts.HTTP2.UpstreamProtocol == options.UpstreamDownstream {
+		clusters = append(clusters, newDownstreamCluster(INGRESS, opts), newDownstreamCluster(EGRESS, opts))
+	}
+
 	if c := newTracingClusterIfRequired(opts); c != nil {
 		clusters = append(clusters, *c)
 	}
 
+	if c := newAdminCluster(opts); c != nil {
+		clusters = append(clusters, *c)
+	}
+
 	return clusters
 }
 
+func newNode(opts options.Options) Node {
+	node := Node{
+		ID:       opts.Node.ID,
+		Cluster:  opts.ServiceName,
+		Metadata: opts.Node.Metadata,
+	}
+	if opts.Node.Region != "" {
+		node.Locality = &Locality{
+			Region:  opts.Node.Region,
+			Zone:    opts.Node.Zone,
+			SubZone: opts.Node.SubZone,
+		}
+	}
+	return node
+}
+
 func New(opts options.Options) (*Config, error) {
 	cfg := Config{
+		Node: newNode(opts),
 		Admin: Admin{
 			opts.AdminLogPath,
 			Address{
 				SocketAddress{
-					"0.0.0.0",
+					opts.AdminAddress,
 					opts.AdminPort,
 				},
 			},
@@ -336,6 +775,13 @@ func New(opts options.Options) (*Config, error) {
 			},
 			Clusters: buildClusterConfigurations(opts),
 		},
+		StatsSinks:     newStatsSinks(opts),
+		StatsConfig:    newStatsConfig(opts),
+		LayeredRuntime: newLayeredRuntime(opts),
+	}
+
+	if l := newMetricsListener(opts); l != nil {
+		cfg.StaticResources.Listeners = append(cfg.StaticResources.Listeners, *l)
 	}
 
 	tracingConfig, err := newTracingConfig(opts)
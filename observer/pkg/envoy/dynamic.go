@@ -0,0 +1,142 @@
+package envoy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/ansel1/merry"
+	"gopkg.in/yaml.v2"
+)
+
+// Names of the secrets TLS material is served as when it is not inlined.
+const (
+	ServerCertificateSecret = "server_certificate"
+	TrustedCASecret         = "trusted_ca"
+)
+
+// Type URLs of resources in discovery responses
+const (
+	ListenerType = "type.googleapis.com/envoy.api.v2.Listener"
+	ClusterType  = "type.googleapis.com/envoy.api.v2.Cluster"
+)
+
+// Resources holds the listeners, clusters and secrets Envoy fetches
+// dynamically.
+type Resources struct {
+	Listeners []Listener
+	Clusters  []Cluster
+	Secrets   []Secret
+}
+
+// Split moves listeners and clusters out of the static resources of cfg and
+// returns a bootstrap that fetches them from source instead. Clusters the
+// bootstrap itself depends on stay static.
+func Split(cfg Config, source ConfigSource) (Config, Resources) {
+	resources := Resources{Listeners: cfg.StaticResources.Listeners}
+
+	bootstrap := cfg
+	bootstrap.StaticResources = StaticResources{}
+	for _, c := range cfg.StaticResources.Clusters {
+		if c.Name == TracingClusterName {
+			bootstrap.StaticResources.Clusters = append(bootstrap.StaticResources.Clusters, c)
+			continue
+		}
+		resources.Clusters = append(resources.Clusters, c)
+	}
+	bootstrap.DynamicResources = &DynamicResources{
+		LDSConfig: source,
+		CDSConfig: source,
+	}
+	return bootstrap, resources
+}
+
+// UseSDS replaces the TLS material inlined in listeners and clusters with
+// references to secrets fetched from source, so certificates can be rotated
+// without replacing listeners.
+func (r *Resources) UseSDS(source ConfigSource) {
+	secrets := map[string]bool{}
+	addSecret := func(secret Secret) {
+		if !secrets[secret.Name] {
+			secrets[secret.Name] = true
+			r.Secrets = append(r.Secrets, secret)
+		}
+	}
+
+	listeners := make([]Listener, len(r.Listeners))
+	for i, l := range r.Listeners {
+		chains := make([]FilterChain, len(l.FilterChains))
+		for j, chain := range l.FilterChains {
+			if chain.TLSContext != nil && len(chain.TLSContext.CommonTLSContext.TLSCertificates) > 0 {
+				tlsContext := *chain.TLSContext
+				certificate := tlsContext.CommonTLSContext.TLSCertificates[0]
+				addSecret(Secret{Name: ServerCertificateSecret, TLSCertificate: &certificate})
+				tlsContext.CommonTLSContext.TLSCertificates = nil
+				tlsContext.CommonTLSContext.TLSCertificateSDSSecretConfigs = []SDSSecretConfig{
+					SDSSecretConfig{Name: ServerCertificateSecret, SDSConfig: source},
+				}
+				chain.TLSContext = &tlsContext
+			}
+			chains[j] = chain
+		}
+		l.FilterChains = chains
+		listeners[i] = l
+	}
+	r.Listeners = listeners
+
+	clusters := make([]Cluster, len(r.Clusters))
+	for i, c := range r.Clusters {
+		common := &c.TLSContext.CommonTLSContext
+		if common.ValidationContext != (ValidationContext{}) {
+			validationContext := common.ValidationContext
+			addSecret(Secret{Name: TrustedCASecret, ValidationContext: &validationContext})
+			common.ValidationContext = ValidationContext{}
+			common.ValidationContextSDSSecretConfig = &SDSSecretConfig{Name: TrustedCASecret, SDSConfig: source}
+		}
+		clusters[i] = c
+	}
+	r.Clusters = clusters
+}
+
+// SplitFiles splits cfg into a bootstrap that watches the discovery response
+// files at ldsPath and cdsPath, and the listener and cluster responses to
+// write to them.
+func SplitFiles(cfg Config, ldsPath, cdsPath string) (Config, DiscoveryResponse, DiscoveryResponse, error) {
+	bootstrap, resources := Split(cfg, ConfigSource{})
+	bootstrap.DynamicResources = &DynamicResources{
+		LDSConfig: ConfigSource{Path: ldsPath},
+		CDSConfig: ConfigSource{Path: cdsPath},
+	}
+
+	listeners := []interface{}{}
+	for _, l := range resources.Listeners {
+		listeners = append(listeners, typedListener{ListenerType, l})
+	}
+	clusters := []interface{}{}
+	for _, c := range resources.Clusters {
+		clusters = append(clusters, typedCluster{ClusterType, c})
+	}
+
+	lds, err := newDiscoveryResponse(listeners)
+	if err != nil {
+		return Config{}, DiscoveryResponse{}, DiscoveryResponse{}, err
+	}
+	cds, err := newDiscoveryResponse(clusters)
+	if err != nil {
+		return Config{}, DiscoveryResponse{}, DiscoveryResponse{}, err
+	}
+	return bootstrap, lds, cds, nil
+}
+
+// newDiscoveryResponse versions resources by their content, so regenerating
+// unchanged resources produces an identical response.
+func newDiscoveryResponse(resources []interface{}) (DiscoveryResponse, error) {
+	serialized, err := yaml.Marshal(resources)
+	if err != nil {
+		return DiscoveryResponse{}, merry.Wrap(err)
+	}
+	sum := sha256.Sum256(serialized)
+	return DiscoveryResponse{
+		VersionInfo: hex.EncodeToString(sum[:8]),
+		Resources:   resources,
+	}, nil
+}
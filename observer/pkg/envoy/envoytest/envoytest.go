@@ -0,0 +1,54 @@
+// Package envoytest provides generated Envoy configs for tests of the
+// packages converting and comparing them.
+package envoytest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+	"github.com/stretchr/testify/require"
+)
+
+// NewConfig returns the config generated for a proxy sampling the given
+// percentage of requests. TLS configs use placeholder certificates.
+func NewConfig(t *testing.T, tlsEnabled bool, sampling float64) envoy.Config {
+	tlsCACert, tlsCert, tlsKey := "", "", ""
+	if tlsEnabled {
+		tlsCACert, tlsCert, tlsKey = "ca", "cert", "key"
+	}
+	opts, err := options.New(options.Options{
+		IngressPort:       15001,
+		EgressPort:        15002,
+		TracingDriver:     envoy.ZIPKIN,
+		TracingHost:       "zipkin",
+		TracingPort:       9411,
+		TracingTagHeaders: []string{"x-tenant"},
+		TracingSampling:   sampling,
+
+		TLSEnabled: tlsEnabled,
+		TLSCACert:  tlsCACert,
+		TLSCert:    tlsCert,
+		TLSKey:     tlsKey,
+
+		AdminPort:             9901,
+		AdminLogPath:          "/dev/null",
+		TimeoutDuration:       time.Minute,
+		EgressTimeoutDuration: time.Minute,
+
+		IngressCircuitBreakers: options.CircuitBreakers{MaxConnections: 1, MaxPendingRequests: 1, MaxRequests: 1, MaxRetries: 1},
+		EgressCircuitBreakers:  options.CircuitBreakers{MaxConnections: 1, MaxPendingRequests: 1, MaxRequests: 1, MaxRetries: 1},
+		ClusterConnection:      options.ConnectionSettings{ConnectTimeout: time.Second},
+		HTTP2:                  options.HTTP2Settings{MaxConcurrentStreams: 100},
+		Drain:                  options.DrainSettings{Type: options.DrainTypeModifyOnly, Timeout: 10 * time.Second},
+
+		MetricsPort: 15090,
+		ServiceName: "service",
+		Node:        options.Node{ID: "node"},
+	})
+	require.Nil(t, err)
+	cfg, err := envoy.New(opts)
+	require.Nil(t, err)
+	return *cfg
+}
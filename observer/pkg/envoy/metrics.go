@@ -0,0 +1,205 @@
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+)
+
+const (
+	adminClusterName     = "admin_cluster"
+	prometheusStatsPath  = "/stats/prometheus"
+	prometheusScrapePath = "/metrics"
+)
+
+// newMetricsListener exposes the admin API's Prometheus endpoint on its own
+// port so the rest of the admin API does not have to be reachable from the
+// network. Both /stats/prometheus and /metrics are served, every other path
+// returns a 404.
+func newMetricsListener(opts options.Options) *Listener {
+	if opts.MetricsPort == 0 {
+		return nil
+	}
+
+	return &Listener{
+		Name: "metrics_listener",
+		Address: Address{
+			SocketAddress{
+				Address:   "0.0.0.0",
+				PortValue: opts.MetricsPort,
+			},
+		},
+		FilterChains: []FilterChain{
+			FilterChain{
+				Filters: []Filter{
+					Filter{
+						Name: "envoy.http_connection_manager",
+						TypedConfig: FilterConfig{
+							ConfigType: "type.googleapis.com/envoy.config.filter.network.http_connection_manager.v2.HttpConnectionManager",
+							StatPrefix: "metrics",
+							CodecType:  "AUTO",
+							RouteConfig: RouteConfig{
+								Name: "metrics_route",
+								VirtualHosts: []VirtualHost{
+									VirtualHost{
+										Name:    "metrics_vhost",
+										Domains: []string{"*"},
+										Routes: []VirtualHostRoute{
+											VirtualHostRoute{
+												Match: VirtualHostRouteMatch{Path: prometheusStatsPath},
+												Route: VirtualHostRouteCluster{Cluster: adminClusterName},
+											},
+											VirtualHostRoute{
+												Match: VirtualHostRouteMatch{Path: prometheusScrapePath},
+												Route: VirtualHostRouteCluster{
+													Cluster:       adminClusterName,
+													PrefixRewrite: prometheusStatsPath,
+												},
+											},
+										},
+									},
+								},
+							},
+							HTTPFilters: []HTTPFilter{
+								HTTPFilter{Name: "envoy.router"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// newAdminCluster points at the local admin API for the metrics listener.
+func newAdminCluster(opts options.Options) *Cluster {
+	if opts.MetricsPort == 0 {
+		return nil
+	}
+
+	return &Cluster{
+		Name:           adminClusterName,
+		ConnectTimeout: Duration(250 * time.Millisecond),
+		Type:           "STATIC",
+		LBPolicy:       "ROUND_ROBIN",
+		Hosts: []ClusterHost{
+			ClusterHost{
+				SocketAddress: &SocketAddress{
+					Address:   adminConnectAddress(opts),
+					PortValue: opts.AdminPort,
+				},
+			},
+		},
+	}
+}
+
+// adminConnectAddress returns the address local clients should use to reach
+// the admin API. Wildcard binds are reached over loopback.
+func adminConnectAddress(opts options.Options) string {
+	if opts.AdminAddress == "0.0.0.0" {
+		return "127.0.0.1"
+	}
+	return opts.AdminAddress
+}
+
+// AdminURL returns the base URL of the admin API for local clients.
+func AdminURL(opts options.Options) string {
+	return "http://" + net.JoinHostPort(adminConnectAddress(opts), strconv.Itoa(opts.AdminPort))
+}
+
+func newStatsConfig(opts options.Options) *StatsConfig {
+	tags := newStatsTagExtractors()
+	fixed := []StatsTag{
+		StatsTag{TagName: "service", FixedValue: opts.ServiceName},
+		StatsTag{TagName: "namespace", FixedValue: opts.ServiceNamespace},
+		StatsTag{TagName: "version", FixedValue: opts.ServiceVersion},
+	}
+	for _, tag := range fixed {
+		if tag.FixedValue != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return &StatsConfig{StatsTags: tags}
+}
+
+// newStatsTagExtractors turns the direction and protocol labels encoded in
+// the proxy's stat prefixes and cluster names into tags:
+//
+//	http.<protocol>_<direction>.*
+//	tcp.<direction>_tcp.*
+//	cluster.<protocol>_<direction>_cluster.*
+//
+// The first capture group of each regex is removed from the stat name and
+// the second one becomes the tag value.
+func newStatsTagExtractors() []StatsTag {
+	directions := labelPattern(INGRESS.Label(), EGRESS.Label())
+	httpProtocols := labelPattern(Protocol(HTTP1).Label(), Protocol(HTTP2).Label())
+	// Clusters forwarding with the client's protocol are labelled downstream
+	clusterProtocols := labelPattern(Protocol(HTTP1).Label(), Protocol(HTTP2).Label(), Protocol(TCP).Label(), downstreamLabel)
+	tcp := regexp.QuoteMeta(Protocol(TCP).Label())
+
+	return []StatsTag{
+		StatsTag{
+			TagName: "direction",
+			Regex:   fmt.Sprintf(`^http\.(?:%s)_((%s)\.)`, httpProtocols, directions),
+		},
+		StatsTag{
+			TagName: "protocol",
+			Regex:   fmt.Sprintf(`^http\.((%s)_)(?:%s)\.`, httpProtocols, directions),
+		},
+		StatsTag{
+			TagName: "direction",
+			Regex:   fmt.Sprintf(`^tcp\.((%s)_)%s\.`, directions, tcp),
+		},
+		StatsTag{
+			TagName: "protocol",
+			Regex:   fmt.Sprintf(`^tcp\.(?:%s)_((%s)\.)`, directions, tcp),
+		},
+		StatsTag{
+			TagName: "direction",
+			Regex:   fmt.Sprintf(`^cluster\.(?:%s)_((%s)_)cluster\.`, clusterProtocols, directions),
+		},
+		StatsTag{
+			TagName: "protocol",
+			Regex:   fmt.Sprintf(`^cluster\.((%s)_)(?:%s)_cluster\.`, clusterProtocols, directions),
+		},
+	}
+}
+
+func labelPattern(labels ...string) string {
+	quoted := []string{}
+	for _, l := range labels {
+		quoted = append(quoted, regexp.QuoteMeta(l))
+	}
+	return strings.Join(quoted, "|")
+}
+
+func newStatsSinks(opts options.Options) []StatsSink {
+	if !opts.StatsSink.Enabled() {
+		return nil
+	}
+
+	sink := StatsSink{
+		Name: "envoy.statsd",
+		TypedConfig: StatsSinkConfig{
+			ConfigType: "type.googleapis.com/envoy.config.metrics.v2.StatsdSink",
+			Address: Address{
+				SocketAddress{
+					Address:   opts.StatsSink.Address,
+					PortValue: opts.StatsSink.Port,
+				},
+			},
+			Prefix: opts.StatsSink.Prefix,
+		},
+	}
+	if opts.StatsSink.Type == options.StatsSinkDogStatsd {
+		sink.Name = "envoy.dog_statsd"
+		sink.TypedConfig.ConfigType = "type.googleapis.com/envoy.config.metrics.v2.DogStatsdSink"
+	}
+	return []StatsSink{sink}
+}
@@ -0,0 +1,85 @@
+package envoy
+
+import (
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+)
+
+// Runtime keys overriding the generated tracing and access log settings.
+// Percentages are integers out of 100 except for RuntimeTracingSampling,
+// which is out of 10000 so that fractional sampling can be expressed.
+const (
+	RuntimeTracingEnabled           = "tracing.global_enabled"
+	RuntimeTracingClientEnabled     = "tracing.client_enabled"
+	RuntimeTracingSampling          = "tracing.random_sampling"
+	RuntimeAccessLogSamplePercent   = "access_log.sample_percent"
+	RuntimeAccessLogErrorStatusCode = "access_log.error_status_code"
+)
+
+// RuntimeKey describes a runtime key the generated config reads.
+type RuntimeKey struct {
+	Name        string
+	Description string
+	// Max is the largest accepted value, zero when the value is not bounded
+	Max int
+}
+
+// RuntimeKeys lists the runtime keys the generated config reads.
+var RuntimeKeys = []RuntimeKey{
+	{RuntimeTracingEnabled, "percentage of requests traced at all, 0 disables tracing", 100},
+	{RuntimeTracingClientEnabled, "percentage of requests with x-client-trace-id that are force traced", 100},
+	{RuntimeTracingSampling, "requests out of 10000 that start a new trace, overrides TRACING_SAMPLING", 10000},
+	{RuntimeAccessLogSamplePercent, "percentage of requests logged when ACCESS_LOG_SAMPLE_PERCENT is below 100", 100},
+	{RuntimeAccessLogErrorStatusCode, "lowest status code logged when ACCESS_LOG_FILTER is errors", 0},
+}
+
+// LookupRuntimeKey returns the description of a runtime key.
+func LookupRuntimeKey(name string) (RuntimeKey, bool) {
+	for _, key := range RuntimeKeys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return RuntimeKey{}, false
+}
+
+// Validate checks that value is a valid value for the key.
+func (key RuntimeKey) Validate(value int) error {
+	if value < 0 || key.Max > 0 && value > key.Max {
+		return merry.Errorf("invalid value [%d] for runtime key [%s]: expected 0 to %d", value, key.Name, key.Max)
+	}
+	return nil
+}
+
+type DiskLayer struct {
+	SymlinkRoot string `yaml:"symlink_root"`
+}
+
+type RuntimeLayer struct {
+	Name       string
+	DiskLayer  *DiskLayer `yaml:"disk_layer,omitempty"`
+	AdminLayer *struct{}  `yaml:"admin_layer,omitempty"`
+}
+
+type LayeredRuntime struct {
+	Layers []RuntimeLayer
+}
+
+// newLayeredRuntime reads runtime values from the runtime directory, which
+// are overridden by values set through the admin API. Envoy reloads the
+// directory whenever it is replaced by a rename, like the ..data symlink of
+// a ConfigMap volume.
+func newLayeredRuntime(opts options.Options) *LayeredRuntime {
+	layers := []RuntimeLayer{}
+	if opts.RuntimeDir != "" {
+		layers = append(layers, RuntimeLayer{
+			Name:      "disk",
+			DiskLayer: &DiskLayer{SymlinkRoot: opts.RuntimeDir},
+		})
+	}
+	layers = append(layers, RuntimeLayer{
+		Name:       "admin",
+		AdminLayer: &struct{}{},
+	})
+	return &LayeredRuntime{Layers: layers}
+}
@@ -3,9 +3,13 @@ package envoy
 // Tracing system identifiers
 const (
 	ZIPKIN = "zipkin"
-	JEAGER = "jeager"
+	JAEGER = "jaeger"
 )
 
+// TracingClusterName is the cluster zipkin spans are sent to. The tracer is
+// created from the bootstrap, so the cluster always stays static.
+const TracingClusterName = "tracing_zipkin_cluster"
+
 type TracingZipkinConfig struct {
 	ConfigType               string `yaml:"@type"`
 	CollectorCluster         string `yaml:"collector_cluster"`
@@ -14,25 +18,25 @@ type TracingZipkinConfig struct {
 }
 
 // ref: https://github.com/jaegertracing/jaeger-client-cpp
-type TracingJeagerConfig struct {
+type TracingJaegerConfig struct {
 	ConfigType   string       `yaml:"@type"`
 	Library      string       `yaml:"library"`
-	JeagerConfig JeagerConfig `yaml:"config"`
+	JaegerConfig JaegerConfig `yaml:"config"`
 }
 
-type JeagerConfig struct {
+type JaegerConfig struct {
 	ServiceName string               `yaml:"service_name"`
-	Sampler     JeagerConfigSampler  `yaml:"sampler"`
-	Reporter    JeagerConfigReporter `yaml:"reporter"`
+	Sampler     JaegerConfigSampler  `yaml:"sampler"`
+	Reporter    JaegerConfigReporter `yaml:"reporter"`
 	Tags        string               `yaml:"tags,omitempty"`
 }
 
-type JeagerConfigSampler struct {
+type JaegerConfigSampler struct {
 	SamplerType string  `yaml:"type"`
 	Param       float32 `yaml:"param"`
 }
 
-type JeagerConfigReporter struct {
+type JaegerConfigReporter struct {
 	CollectorEndpoint string `yaml:"endpoint"`
 }
 
@@ -1,6 +1,9 @@
 package envoy
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 type Protocol int
 
@@ -11,6 +14,21 @@ const (
 	TCP
 )
 
+// Label returns the short protocol name used in stat prefixes, cluster
+// names and stat tags.
+func (protocol Protocol) Label() string {
+	switch protocol {
+	case HTTP1:
+		return "h1"
+	case HTTP2:
+		return "h2"
+	case TCP:
+		return "tcp"
+	default:
+		return ""
+	}
+}
+
 type TrafficDirection int
 
 const (
@@ -30,6 +48,40 @@ func (direction TrafficDirection) String() string {
 	}
 }
 
+// Label returns the short direction name used in stat prefixes, cluster
+// names and stat tags.
+func (direction TrafficDirection) Label() string {
+	switch direction {
+	case INGRESS:
+		return "ingress"
+	case EGRESS:
+		return "egress"
+	default:
+		return ""
+	}
+}
+
+// Duration renders a time.Duration in the seconds based format expected by
+// Envoy's config parser, e.g. "0.25s".
+type Duration time.Duration
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return strconv.FormatFloat(time.Duration(d).Seconds(), 'f', -1, 64) + "s", nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
 type SocketAddress struct {
 	Address   string
 	PortValue int `yaml:"port_value"`
@@ -45,17 +97,33 @@ type Admin struct {
 }
 
 type FilterChainMatch struct {
-	ApplicationProtocols string `yaml:"application_protocols,omitempty"`
-	TransportProtocol    string `yaml:"transport_protocol,omitempty"`
+	DestinationPort      int      `yaml:"destination_port,omitempty"`
+	ApplicationProtocols []string `yaml:"application_protocols,omitempty"`
+	TransportProtocol    string   `yaml:"transport_protocol,omitempty"`
+}
+
+type RegexMatcher struct {
+	GoogleRE2 struct{} `yaml:"google_re2"`
+	Regex     string
+}
+
+type HeaderMatcher struct {
+	Name           string
+	ExactMatch     string        `yaml:"exact_match,omitempty"`
+	SuffixMatch    string        `yaml:"suffix_match,omitempty"`
+	SafeRegexMatch *RegexMatcher `yaml:"safe_regex_match,omitempty"`
 }
 
 type VirtualHostRouteMatch struct {
-	Prefix string
+	Prefix  string          `yaml:",omitempty"`
+	Path    string          `yaml:",omitempty"`
+	Headers []HeaderMatcher `yaml:",omitempty"`
 }
 
 type VirtualHostRouteCluster struct {
-	Cluster string
-	Timeout *time.Duration `yaml:"timeout,omitempty"`
+	Cluster       string
+	Timeout       *Duration `yaml:"timeout,omitempty"`
+	PrefixRewrite string    `yaml:"prefix_rewrite,omitempty"`
 }
 type VirtualHostRouteRedirect struct {
 	PathRedirect  string `yaml:"path_redirect"`
@@ -68,10 +136,23 @@ type VirtualHostRoute struct {
 	Redirect VirtualHostRouteRedirect `yaml:",omitempty"`
 }
 
+type RetryBackOff struct {
+	BaseInterval Duration `yaml:"base_interval"`
+	MaxInterval  Duration `yaml:"max_interval,omitempty"`
+}
+
+type RetryPolicy struct {
+	RetryOn       string        `yaml:"retry_on"`
+	NumRetries    int           `yaml:"num_retries"`
+	PerTryTimeout Duration      `yaml:"per_try_timeout,omitempty"`
+	RetryBackOff  *RetryBackOff `yaml:"retry_back_off,omitempty"`
+}
+
 type VirtualHost struct {
-	Name    string
-	Domains []string
-	Routes  []VirtualHostRoute
+	Name        string
+	Domains     []string
+	Routes      []VirtualHostRoute
+	RetryPolicy *RetryPolicy `yaml:"retry_policy,omitempty"`
 }
 
 type RouteConfig struct {
@@ -84,22 +165,53 @@ type HTTPFilter struct {
 	Config struct{} `yaml:"typed_config"`
 }
 
+type CustomTagLiteral struct {
+	Value string
+}
+
+type CustomTagHeader struct {
+	Name string
+}
+
+type CustomTag struct {
+	Tag           string
+	Literal       *CustomTagLiteral `yaml:",omitempty"`
+	RequestHeader *CustomTagHeader  `yaml:"request_header,omitempty"`
+}
+
 type FilterConfigTracing struct {
-	OverallSampling Value    `yaml:"overall_sampling,omitempty"`
-	CustomTags      []string `yaml:"custom_tags,omitempty"`
+	ClientSampling  Value       `yaml:"client_sampling"`
+	RandomSampling  Value       `yaml:"random_sampling"`
+	OverallSampling Value       `yaml:"overall_sampling,omitempty"`
+	CustomTags      []CustomTag `yaml:"custom_tags,omitempty"`
+}
+
+type HeaderKeyFormat struct {
+	ProperCaseWords *struct{} `yaml:"proper_case_words,omitempty"`
+}
+
+type HTTPProtocolOptions struct {
+	AllowAbsoluteURL     bool             `yaml:"allow_absolute_url,omitempty"`
+	AcceptHTTP10         bool             `yaml:"accept_http_10,omitempty"`
+	DefaultHostForHTTP10 string           `yaml:"default_host_for_http_10,omitempty"`
+	HeaderKeyFormat      *HeaderKeyFormat `yaml:"header_key_format,omitempty"`
 }
 
 type FilterConfig struct {
-	ConfigType        string              `yaml:"@type"`
-	StatPrefix        string              `yaml:"stat_prefix"`
-	CodecType         string              `yaml:"codec_type,omitempty"`
-	GenerateRequestID bool                `yaml:"generate_request_id,omitempty"`
-	UseRemoteAddress  bool                `yaml:"use_remote_address,omitempty"`
-	TrustedHopsCount  int                 `yaml:"xff_num_trusted_hops,omitempty"`
-	Tracing           FilterConfigTracing `yaml:",omitempty"`
-	RouteConfig       RouteConfig         `yaml:"route_config,omitempty"`
-	HTTPFilters       []HTTPFilter        `yaml:"http_filters,omitempty"`
-	Cluster           string              `yaml:"cluster,omitempty"`
+	ConfigType          string               `yaml:"@type"`
+	StatPrefix          string               `yaml:"stat_prefix"`
+	CodecType           string               `yaml:"codec_type,omitempty"`
+	GenerateRequestID   bool                 `yaml:"generate_request_id,omitempty"`
+	UseRemoteAddress    bool                 `yaml:"use_remote_address,omitempty"`
+	TrustedHopsCount    int                  `yaml:"xff_num_trusted_hops,omitempty"`
+	HTTPProtocolOptions *HTTPProtocolOptions `yaml:"http_protocol_options,omitempty"`
+	Tracing             FilterConfigTracing  `yaml:",omitempty"`
+	RouteConfig         RouteConfig          `yaml:"route_config,omitempty"`
+	HTTPFilters         []HTTPFilter         `yaml:"http_filters,omitempty"`
+	Cluster             string               `yaml:"cluster,omitempty"`
+	IdleTimeout         *Duration            `yaml:"idle_timeout,omitempty"`
+	DrainTimeout        *Duration            `yaml:"drain_timeout,omitempty"`
+	AccessLog           []AccessLog          `yaml:"access_log,omitempty"`
 }
 
 type Filter struct {
@@ -122,9 +234,11 @@ type ValidationContext struct {
 }
 
 type CommonTLSContext struct {
-	ALPNProtocols     string            `yaml:"alpn_protocols,omitempty"`
-	TLSCertificates   []TLSCertificate  `yaml:"tls_certificates,omitempty"`
-	ValidationContext ValidationContext `yaml:"validation_context,omitempty"`
+	ALPNProtocols                    []string          `yaml:"alpn_protocols,omitempty"`
+	TLSCertificates                  []TLSCertificate  `yaml:"tls_certificates,omitempty"`
+	TLSCertificateSDSSecretConfigs   []SDSSecretConfig `yaml:"tls_certificate_sds_secret_configs,omitempty"`
+	ValidationContext                ValidationContext `yaml:"validation_context,omitempty"`
+	ValidationContextSDSSecretConfig *SDSSecretConfig  `yaml:"validation_context_sds_secret_config,omitempty"`
 }
 
 type TLSContext struct {
@@ -143,30 +257,78 @@ type ListenerFilter struct {
 
 type Listener struct {
 	Name            string
-	Direction       string `yaml:"traffic_direction"`
+	Direction       string `yaml:"traffic_direction,omitempty"`
 	Address         Address
 	Transparent     bool
+	DrainType       string           `yaml:"drain_type,omitempty"`
 	ListenerFilters []ListenerFilter `yaml:"listener_filters"`
 	FilterChains    []FilterChain    `yaml:"filter_chains"`
 }
 
 type HTTP2ProtocolOptions struct {
-	MaxConcurrentStreams int `yaml:"max_concurrent_streams"`
+	MaxConcurrentStreams        int `yaml:"max_concurrent_streams"`
+	InitialStreamWindowSize     int `yaml:"initial_stream_window_size,omitempty"`
+	InitialConnectionWindowSize int `yaml:"initial_connection_window_size,omitempty"`
+}
+
+type CircuitBreakerThresholds struct {
+	Priority           string
+	MaxConnections     int `yaml:"max_connections"`
+	MaxPendingRequests int `yaml:"max_pending_requests"`
+	MaxRequests        int `yaml:"max_requests"`
+	MaxRetries         int `yaml:"max_retries"`
+}
+
+type CircuitBreakers struct {
+	Thresholds []CircuitBreakerThresholds
+}
+
+type OutlierDetection struct {
+	Consecutive5xx     int      `yaml:"consecutive_5xx"`
+	Interval           Duration `yaml:"interval"`
+	BaseEjectionTime   Duration `yaml:"base_ejection_time"`
+	MaxEjectionPercent int      `yaml:"max_ejection_percent"`
+}
+
+type CommonHTTPProtocolOptions struct {
+	IdleTimeout Duration `yaml:"idle_timeout,omitempty"`
+}
+
+type TCPKeepalive struct {
+	KeepaliveProbes   int `yaml:"keepalive_probes,omitempty"`
+	KeepaliveTime     int `yaml:"keepalive_time,omitempty"`
+	KeepaliveInterval int `yaml:"keepalive_interval,omitempty"`
+}
+
+type UpstreamConnectionOptions struct {
+	TCPKeepalive TCPKeepalive `yaml:"tcp_keepalive"`
 }
 
 type Cluster struct {
-	Name                 string
-	ConnectTimeout       string `yaml:"connect_timeout"`
-	Type                 string
-	LBPolicy             string               `yaml:"lb_policy"`
-	DnsLookupFamily      string               `yaml:"dns_lookup_family,omitempty"`
-	HTTP2ProtocolOptions HTTP2ProtocolOptions `yaml:"http2_protocol_options,omitempty"`
-	TLSContext           TLSContext           `yaml:"tls_context,omitempty"`
-	Hosts                []ClusterHost        `yaml:"hosts,omitempty"`
+	Name                      string
+	ConnectTimeout            Duration `yaml:"connect_timeout"`
+	Type                      string
+	LBPolicy                  string                     `yaml:"lb_policy"`
+	DnsLookupFamily           string                     `yaml:"dns_lookup_family,omitempty"`
+	CleanupInterval           Duration                   `yaml:"cleanup_interval,omitempty"`
+	CommonHTTPProtocolOptions *CommonHTTPProtocolOptions `yaml:"common_http_protocol_options,omitempty"`
+	HTTPProtocolOptions       *HTTPProtocolOptions       `yaml:"http_protocol_options,omitempty"`
+	HTTP2ProtocolOptions      HTTP2ProtocolOptions       `yaml:"http2_protocol_options,omitempty"`
+	ProtocolSelection         string                     `yaml:"protocol_selection,omitempty"`
+	UpstreamConnectionOptions *UpstreamConnectionOptions `yaml:"upstream_connection_options,omitempty"`
+	TLSContext                TLSContext                 `yaml:"tls_context,omitempty"`
+	Hosts                     []ClusterHost              `yaml:"hosts,omitempty"`
+	CircuitBreakers           *CircuitBreakers           `yaml:"circuit_breakers,omitempty"`
+	OutlierDetection          *OutlierDetection          `yaml:"outlier_detection,omitempty"`
+}
+
+type Pipe struct {
+	Path string
 }
 
 type ClusterHost struct {
-	SocketAddress SocketAddress `yaml:"socket_address"`
+	SocketAddress *SocketAddress `yaml:"socket_address,omitempty"`
+	Pipe          *Pipe          `yaml:"pipe,omitempty"`
 }
 
 type StaticResources struct {
@@ -174,12 +336,104 @@ type StaticResources struct {
 	Clusters  []Cluster
 }
 
+type EnvoyGRPC struct {
+	ClusterName string `yaml:"cluster_name"`
+}
+
+type GRPCService struct {
+	EnvoyGRPC EnvoyGRPC `yaml:"envoy_grpc"`
+}
+
+type APIConfigSource struct {
+	APIType      string        `yaml:"api_type"`
+	GRPCServices []GRPCService `yaml:"grpc_services"`
+}
+
+// ConfigSource tells Envoy where to fetch dynamic resources from, either a
+// file it watches or a management server.
+type ConfigSource struct {
+	Path            string           `yaml:"path,omitempty"`
+	APIConfigSource *APIConfigSource `yaml:"api_config_source,omitempty"`
+}
+
+type DynamicResources struct {
+	LDSConfig ConfigSource `yaml:"lds_config"`
+	CDSConfig ConfigSource `yaml:"cds_config"`
+}
+
+type SDSSecretConfig struct {
+	Name      string
+	SDSConfig ConfigSource `yaml:"sds_config"`
+}
+
+type Secret struct {
+	Name              string
+	TLSCertificate    *TLSCertificate    `yaml:"tls_certificate,omitempty"`
+	ValidationContext *ValidationContext `yaml:"validation_context,omitempty"`
+}
+
+// DiscoveryResponse is the content of a file a path config source points
+// at.
+type DiscoveryResponse struct {
+	VersionInfo string        `yaml:"version_info"`
+	Resources   []interface{} `yaml:"resources"`
+}
+
+type typedListener struct {
+	ConfigType string `yaml:"@type"`
+	Listener   `yaml:",inline"`
+}
+
+type typedCluster struct {
+	ConfigType string `yaml:"@type"`
+	Cluster    `yaml:",inline"`
+}
+
+type StatsSinkConfig struct {
+	ConfigType string  `yaml:"@type"`
+	Address    Address `yaml:"address"`
+	Prefix     string  `yaml:"prefix,omitempty"`
+}
+
+type StatsSink struct {
+	Name        string
+	TypedConfig StatsSinkConfig `yaml:"typed_config"`
+}
+
+type StatsTag struct {
+	TagName    string `yaml:"tag_name"`
+	Regex      string `yaml:"regex,omitempty"`
+	FixedValue string `yaml:"fixed_value,omitempty"`
+}
+
+type StatsConfig struct {
+	StatsTags []StatsTag `yaml:"stats_tags"`
+}
+
+type Locality struct {
+	Region  string `yaml:"region,omitempty"`
+	Zone    string `yaml:"zone,omitempty"`
+	SubZone string `yaml:"sub_zone,omitempty"`
+}
+
+type Node struct {
+	ID       string            `yaml:"id"`
+	Cluster  string            `yaml:"cluster"`
+	Locality *Locality         `yaml:"locality,omitempty"`
+	Metadata map[string]string `yaml:"metadata,omitempty"`
+}
+
 type Config struct {
-	Admin           Admin
-	StaticResources StaticResources `yaml:"static_resources"`
-	Tracing         Tracing
+	Node             Node
+	Admin            Admin
+	StaticResources  StaticResources   `yaml:"static_resources"`
+	DynamicResources *DynamicResources `yaml:"dynamic_resources,omitempty"`
+	StatsSinks       []StatsSink       `yaml:"stats_sinks,omitempty"`
+	StatsConfig      *StatsConfig      `yaml:"stats_config,omitempty"`
+	LayeredRuntime   *LayeredRuntime   `yaml:"layered_runtime,omitempty"`
+	Tracing          Tracing
 }
 
 type Value struct {
-	Value float32 `yaml:"value"`
+	Value float64 `yaml:"value"`
 }
@@ -0,0 +1,117 @@
+package inject
+
+import (
+	"strings"
+
+	"github.com/omnition/omnition-observer/observer/pkg/options"
+)
+
+// Container names and annotations used to inject the observer into pods
+const (
+	ProxyContainerName = "omnition-observer"
+	InitContainerName  = "omnition-observer-init"
+
+	// InjectAnnotation set to "false" opts a pod out of injection
+	InjectAnnotation = options.AnnotationPrefix + "inject"
+	// StatusAnnotation is added to pods once the observer has been injected
+	StatusAnnotation = options.AnnotationPrefix + "status"
+)
+
+// Config describes the containers added to pods.
+type Config struct {
+	ProxyImage      string
+	InitImage       string
+	ImagePullPolicy string
+}
+
+// DefaultConfig returns the images published for this release.
+func DefaultConfig() Config {
+	return Config{
+		ProxyImage:      "omnition/omnition-observer:latest",
+		InitImage:       "omnition/omnition-observer-init:latest",
+		ImagePullPolicy: "IfNotPresent",
+	}
+}
+
+type EnvVar struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+type ContainerPort struct {
+	Name          string `json:"name,omitempty" yaml:"name,omitempty"`
+	ContainerPort int    `json:"containerPort" yaml:"containerPort"`
+}
+
+type Capabilities struct {
+	Add []string `json:"add,omitempty" yaml:"add,omitempty"`
+}
+
+type SecurityContext struct {
+	Capabilities *Capabilities `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+	Privileged   *bool         `json:"privileged,omitempty" yaml:"privileged,omitempty"`
+}
+
+type Container struct {
+	Name            string           `json:"name" yaml:"name"`
+	Image           string           `json:"image" yaml:"image"`
+	ImagePullPolicy string           `json:"imagePullPolicy,omitempty" yaml:"imagePullPolicy,omitempty"`
+	Ports           []ContainerPort  `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Env             []EnvVar         `json:"env,omitempty" yaml:"env,omitempty"`
+	SecurityContext *SecurityContext `json:"securityContext,omitempty" yaml:"securityContext,omitempty"`
+}
+
+// Required reports whether the observer should be injected into a pod with
+// the given annotations and containers. Pods that opted out or already run
+// the observer are skipped.
+func Required(annotations map[string]string, containerNames []string) bool {
+	if strings.EqualFold(annotations[InjectAnnotation], "false") {
+		return false
+	}
+	for _, name := range containerNames {
+		if name == ProxyContainerName || name == InitContainerName {
+			return false
+		}
+	}
+	return true
+}
+
+// Sidecars returns the init and proxy containers for a pod with the given
+// annotations.
+func Sidecars(cfg Config, annotations map[string]string) (Container, Container, error) {
+	values, err := options.ParseAnnotations(annotations)
+	if err != nil {
+		return Container{}, Container{}, err
+	}
+
+	privileged := true
+	initContainer := Container{
+		Name:            InitContainerName,
+		Image:           cfg.InitImage,
+		ImagePullPolicy: cfg.ImagePullPolicy,
+		SecurityContext: &SecurityContext{
+			Capabilities: &Capabilities{Add: []string{"NET_ADMIN"}},
+			Privileged:   &privileged,
+		},
+	}
+
+	proxyContainer := Container{
+		Name:            ProxyContainerName,
+		Image:           cfg.ProxyImage,
+		ImagePullPolicy: cfg.ImagePullPolicy,
+		Ports: []ContainerPort{
+			ContainerPort{Name: "metrics", ContainerPort: 15090},
+		},
+	}
+
+	for _, v := range values {
+		env := EnvVar{Name: v.Env, Value: v.Value}
+		if v.Init {
+			initContainer.Env = append(initContainer.Env, env)
+		} else {
+			proxyContainer.Env = append(proxyContainer.Env, env)
+		}
+	}
+
+	return initContainer, proxyContainer, nil
+}
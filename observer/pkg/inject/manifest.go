@@ -0,0 +1,254 @@
+package inject
+
+import (
+	"io"
+
+	"github.com/ansel1/merry"
+	"gopkg.in/yaml.v3"
+)
+
+// Paths to the pod template of each supported workload kind
+var podTemplatePaths = map[string][]string{
+	"Pod":                   nil,
+	"Deployment":            []string{"spec", "template"},
+	"StatefulSet":           []string{"spec", "template"},
+	"DaemonSet":             []string{"spec", "template"},
+	"ReplicaSet":            []string{"spec", "template"},
+	"ReplicationController": []string{"spec", "template"},
+	"Job":                   []string{"spec", "template"},
+	"CronJob":               []string{"spec", "jobTemplate", "spec", "template"},
+}
+
+// Manifests reads a stream of YAML documents and writes them back with the
+// observer containers added to every workload's pod template. Documents
+// that are not workloads, opted out or already injected are written
+// unchanged, so running it twice yields the same output.
+func Manifests(cfg Config, r io.Reader, w io.Writer) error {
+	decoder := yaml.NewDecoder(r)
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+
+	for {
+		document := yaml.Node{}
+		if err := decoder.Decode(&document); err == io.EOF {
+			break
+		} else if err != nil {
+			return merry.Wrap(err)
+		}
+		if len(document.Content) == 0 {
+			continue
+		}
+
+		if err := injectObject(cfg, document.Content[0]); err != nil {
+			return err
+		}
+		if err := encoder.Encode(&document); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	return merry.Wrap(encoder.Close())
+}
+
+func injectObject(cfg Config, object *yaml.Node) error {
+	if object.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	kind := scalarValue(mappingValue(object, "kind"))
+	if kind == "List" {
+		items := mappingValue(object, "items")
+		if items == nil {
+			return nil
+		}
+		for _, item := range items.Content {
+			if err := injectObject(cfg, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, ok := podTemplatePaths[kind]
+	if !ok {
+		return nil
+	}
+	template := object
+	for _, key := range path {
+		template = mappingValue(template, key)
+		if template == nil || template.Kind != yaml.MappingNode {
+			return nil
+		}
+	}
+
+	return injectPodTemplate(cfg, template)
+}
+
+func injectPodTemplate(cfg Config, template *yaml.Node) error {
+	metadata, err := ensureMapping(template, "metadata")
+	if err != nil {
+		return err
+	}
+	spec, err := ensureMapping(template, "spec")
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{}
+	if node := mappingValue(metadata, "annotations"); node != nil {
+		if err := node.Decode(&annotations); err != nil {
+			return merry.Wrap(err)
+		}
+	}
+
+	names := []string{}
+	for _, key := range []string{"initContainers", "containers"} {
+		containers := []struct {
+			Name string `yaml:"name"`
+		}{}
+		if node := mappingValue(spec, key); node != nil {
+			if err := node.Decode(&containers); err != nil {
+				return merry.Wrap(err)
+			}
+		}
+		for _, c := range containers {
+			names = append(names, c.Name)
+		}
+	}
+	if !Required(annotations, names) {
+		return nil
+	}
+
+	initContainer, proxyContainer, err := Sidecars(cfg, annotations)
+	if err != nil {
+		return err
+	}
+
+	initContainers, err := ensureSequence(spec, "initContainers")
+	if err != nil {
+		return err
+	}
+	if err := appendValue(initContainers, initContainer); err != nil {
+		return err
+	}
+	containers, err := ensureSequence(spec, "containers")
+	if err != nil {
+		return err
+	}
+	if err := appendValue(containers, proxyContainer); err != nil {
+		return err
+	}
+	podAnnotations, err := ensureMapping(metadata, "annotations")
+	if err != nil {
+		return err
+	}
+	return setScalar(podAnnotations, StatusAnnotation, "injected")
+}
+
+// Kinds named in errors about unexpected manifest content
+var kindNames = map[yaml.Kind]string{
+	yaml.MappingNode:  "mapping",
+	yaml.SequenceNode: "sequence",
+	yaml.ScalarNode:   "scalar",
+}
+
+// resolve returns the node an alias refers to.
+func resolve(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// mappingIndex returns the index of the value of key in the mapping's
+// content, or -1 when the key is missing.
+func mappingIndex(mapping *yaml.Node, key string) int {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	mapping = resolve(mapping)
+	i := mappingIndex(mapping, key)
+	if i < 0 {
+		return nil
+	}
+	return resolve(mapping.Content[i])
+}
+
+func scalarValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null"
+}
+
+// ensureChild returns the value of key, following aliases. Missing and null
+// values are replaced with an empty node of the given kind, while values of
+// another kind are rejected instead of being overwritten.
+func ensureChild(mapping *yaml.Node, key string, kind yaml.Kind, tag string) (*yaml.Node, error) {
+	empty := &yaml.Node{Kind: kind, Tag: tag}
+	i := mappingIndex(mapping, key)
+	if i < 0 {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, empty)
+		return empty, nil
+	}
+	value := resolve(mapping.Content[i])
+	if isNull(value) {
+		// Replacing an anchor would leave its aliases dangling, while
+		// replacing an alias leaves the other references null
+		if mapping.Content[i].Anchor != "" {
+			return nil, merry.Errorf("cannot replace the anchored null value of [%s] at line %d", key, value.Line)
+		}
+		mapping.Content[i] = empty
+		return empty, nil
+	}
+	if value.Kind != kind {
+		return nil, merry.Errorf("expected a %s for [%s] at line %d", kindNames[kind], key, value.Line)
+	}
+	return value, nil
+}
+
+func ensureMapping(mapping *yaml.Node, key string) (*yaml.Node, error) {
+	return ensureChild(mapping, key, yaml.MappingNode, "!!map")
+}
+
+func ensureSequence(mapping *yaml.Node, key string) (*yaml.Node, error) {
+	return ensureChild(mapping, key, yaml.SequenceNode, "!!seq")
+}
+
+// setScalar sets key to a string. Existing values are replaced rather than
+// modified, since they may be anchors referenced elsewhere.
+func setScalar(mapping *yaml.Node, key, value string) error {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	i := mappingIndex(mapping, key)
+	if i < 0 {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, node)
+		return nil
+	}
+	if current := resolve(mapping.Content[i]); current.Kind != yaml.ScalarNode {
+		return merry.Errorf("expected a %s for [%s] at line %d", kindNames[yaml.ScalarNode], key, current.Line)
+	}
+	mapping.Content[i] = node
+	return nil
+}
+
+func appendValue(sequence *yaml.Node, value interface{}) error {
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return merry.Wrap(err)
+	}
+	sequence.Content = append(sequence.Content, node)
+	return nil
+}
@@ -0,0 +1,226 @@
+package inject
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+type podSpec struct {
+	InitContainers []Container `yaml:"initContainers"`
+	Containers     []Container `yaml:"containers"`
+}
+
+type workload struct {
+	Kind     string
+	Metadata struct {
+		Name string
+	}
+	Spec struct {
+		Template struct {
+			Metadata struct {
+				Annotations map[string]string
+			}
+			Spec podSpec
+		}
+	}
+}
+
+func decodeWorkloads(t *testing.T, manifest []byte) []workload {
+	workloads := []workload{}
+	decoder := yaml.NewDecoder(bytes.NewReader(manifest))
+	for {
+		w := workload{}
+		if err := decoder.Decode(&w); err == io.EOF {
+			break
+		} else {
+			require.Nil(t, err)
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads
+}
+
+func containerNames(containers []Container) []string {
+	names := []string{}
+	for _, c := range containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestManifests(t *testing.T) {
+	input, err := ioutil.ReadFile(filepath.Join("testdata", "workloads.yaml"))
+	require.Nil(t, err)
+	cfg := DefaultConfig()
+
+	t.Run("Should inject workloads", func(t *testing.T) {
+		// When
+		out := bytes.Buffer{}
+		err := Manifests(cfg, bytes.NewReader(input), &out)
+
+		// Then
+		assert.Nil(t, err)
+		workloads := decodeWorkloads(t, out.Bytes())
+		assert.Len(t, workloads, 5)
+
+		deployment := workloads[0]
+		assert.Equal(t, "Deployment", deployment.Kind)
+		assert.Equal(t, []string{"migrate", "omnition-observer-init"}, containerNames(deployment.Spec.Template.Spec.InitContainers))
+		assert.Equal(t, []string{"checkout", "omnition-observer"}, containerNames(deployment.Spec.Template.Spec.Containers))
+		assert.Equal(t, "injected", deployment.Spec.Template.Metadata.Annotations[StatusAnnotation])
+		proxy := deployment.Spec.Template.Spec.Containers[1]
+		assert.Equal(t, "omnition/omnition-observer:latest", proxy.Image)
+		assert.Equal(t, []ContainerPort{ContainerPort{Name: "metrics", ContainerPort: 15090}}, proxy.Ports)
+		assert.Equal(t, []EnvVar{EnvVar{Name: "TRACING_HOST", Value: "zipkin"}}, proxy.Env)
+		assert.Equal(t, []string{"NET_ADMIN"}, deployment.Spec.Template.Spec.InitContainers[1].SecurityContext.Capabilities.Add)
+
+		statefulSet := workloads[1]
+		assert.Equal(t, "StatefulSet", statefulSet.Kind)
+		assert.Equal(t, []string{"omnition-observer-init"}, containerNames(statefulSet.Spec.Template.Spec.InitContainers))
+		assert.Equal(t, []string{"cart", "omnition-observer"}, containerNames(statefulSet.Spec.Template.Spec.Containers))
+
+		daemonSet := workloads[2]
+		assert.Equal(t, "DaemonSet", daemonSet.Kind)
+		assert.Empty(t, daemonSet.Spec.Template.Spec.InitContainers)
+		assert.Equal(t, []string{"agent"}, containerNames(daemonSet.Spec.Template.Spec.Containers))
+
+		job := workloads[3]
+		assert.Equal(t, "Job", job.Kind)
+		assert.Equal(t, []string{"omnition-observer-init"}, containerNames(job.Spec.Template.Spec.InitContainers))
+		assert.Equal(t, []string{"report", "omnition-observer"}, containerNames(job.Spec.Template.Spec.Containers))
+
+		assert.Equal(t, "Service", workloads[4].Kind)
+		assert.True(t, strings.HasPrefix(out.String(), "# Checkout service\n"))
+	})
+
+	t.Run("Should be idempotent", func(t *testing.T) {
+		// When
+		first := bytes.Buffer{}
+		assert.Nil(t, Manifests(cfg, bytes.NewReader(input), &first))
+		second := bytes.Buffer{}
+		err := Manifests(cfg, bytes.NewReader(first.Bytes()), &second)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, first.String(), second.String())
+	})
+
+	t.Run("Should inject cron jobs and lists", func(t *testing.T) {
+		// When
+		manifest := `
+kind: List
+items:
+  - kind: CronJob
+    spec:
+      jobTemplate:
+        spec:
+          template:
+            spec:
+              containers:
+                - name: cleanup
+`
+		out := bytes.Buffer{}
+		err := Manifests(cfg, strings.NewReader(manifest), &out)
+
+		// Then
+		assert.Nil(t, err)
+		list := struct {
+			Items []struct {
+				Spec struct {
+					JobTemplate struct {
+						Spec struct {
+							Template struct {
+								Spec podSpec
+							}
+						}
+					} `yaml:"jobTemplate"`
+				}
+			}
+		}{}
+		assert.Nil(t, yaml.Unmarshal(out.Bytes(), &list))
+		spec := list.Items[0].Spec.JobTemplate.Spec.Template.Spec
+		assert.Equal(t, []string{"omnition-observer-init"}, containerNames(spec.InitContainers))
+		assert.Equal(t, []string{"cleanup", "omnition-observer"}, containerNames(spec.Containers))
+	})
+
+	t.Run("Should follow aliases and replace null values", func(t *testing.T) {
+		// When
+		manifest := `
+kind: List
+items:
+  - kind: Pod
+    metadata:
+      name: first
+      labels: &none
+      annotations: *none
+    spec: &spec
+      containers:
+        - name: app
+  - kind: Pod
+    metadata:
+      name: second
+      annotations:
+    spec: *spec
+`
+		out := bytes.Buffer{}
+		err := Manifests(cfg, strings.NewReader(manifest), &out)
+
+		// Then
+		assert.Nil(t, err)
+		list := struct {
+			Items []struct {
+				Metadata struct {
+					Labels      map[string]string
+					Annotations map[string]string
+				}
+				Spec podSpec
+			}
+		}{}
+		assert.Nil(t, yaml.Unmarshal(out.Bytes(), &list))
+		assert.Equal(t, "injected", list.Items[0].Metadata.Annotations[StatusAnnotation])
+		assert.Nil(t, list.Items[0].Metadata.Labels, "Other references to a null anchor should stay null")
+		assert.Equal(t, []string{"app", "omnition-observer"}, containerNames(list.Items[0].Spec.Containers))
+		assert.Equal(t, []string{"app", "omnition-observer"}, containerNames(list.Items[1].Spec.Containers), "Aliased specs should not be replaced or injected twice")
+	})
+
+	t.Run("Should fail on values of another kind", func(t *testing.T) {
+		// When
+		manifest := `
+kind: Pod
+metadata: web
+spec:
+  containers:
+    - name: app
+`
+		err := Manifests(cfg, strings.NewReader(manifest), ioutil.Discard)
+
+		// Then
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "expected a mapping for [metadata]")
+	})
+
+	t.Run("Should fail on invalid annotations", func(t *testing.T) {
+		// When
+		manifest := `
+kind: Pod
+metadata:
+  annotations:
+    observer.omnition.io/Tracing_Host: zipkin
+spec:
+  containers:
+    - name: app
+`
+		err := Manifests(cfg, strings.NewReader(manifest), ioutil.Discard)
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,246 @@
+package options
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// AnnotationPrefix is the namespace of the pod annotations configuring the
+// observer, e.g. observer.omnition.io/sampling.
+const AnnotationPrefix = "observer.omnition.io/"
+
+// Annotation documents a pod annotation and the environment variable it
+// sets on the observer.
+type Annotation struct {
+	// Name of the annotation without the prefix
+	Name string
+	// Env is the environment variable set from the annotation. Annotations
+	// without one control the injector itself.
+	Env string
+	// Init annotations are read by the init container instead of the proxy
+	Init        bool
+	Description string
+	validate    func(value string) error
+}
+
+// Annotations lists every supported annotation.
+var Annotations = []Annotation{
+	{Name: "inject", Description: `set to "false" to skip injecting the observer`, validate: validateBool},
+	{Name: "status", Description: "set by the injector once the observer has been added"},
+
+	{Name: "exclude-inbound-ports", Env: "INGRESS_EXCLUDE_PORTS", Init: true, Description: "comma separated ports whose inbound traffic bypasses the observer", validate: validatePortList(",")},
+	{Name: "exclude-outbound-ports", Env: "EGRESS_EXCLUDE_PORTS", Init: true, Description: "comma separated ports whose outbound traffic bypasses the observer", validate: validatePortList(",")},
+
+	{Name: "service-name", Env: "SERVICE_NAME", Description: "service name used in traces and metrics"},
+	{Name: "service-namespace", Env: "SERVICE_NAMESPACE", Description: "namespace tag added to metrics"},
+	{Name: "service-version", Env: "SERVICE_VERSION", Description: "version tag added to metrics"},
+
+	{Name: "tracing-driver", Env: "TRACING_DRIVER", Description: "zipkin or jaeger", validate: validateOneOf("zipkin", "jaeger")},
+	{Name: "tracing-host", Env: "TRACING_HOST", Description: "address of the trace collector"},
+	{Name: "tracing-port", Env: "TRACING_PORT", Description: "port of the trace collector", validate: validatePort},
+	{Name: "tracing-tag-headers", Env: "TRACING_TAG_HEADERS", Description: "comma separated request headers added to spans as tags"},
+	{Name: "sampling", Env: "TRACING_SAMPLING", Description: "percentage of requests that start a new trace", validate: validatePercentage},
+
+	{Name: "ingress-timeout", Env: "INGRESS_TIMEOUT", Description: "ingress request timeout", validate: validateDuration},
+	{Name: "egress-timeout", Env: "EGRESS_TIMEOUT", Description: "egress request timeout", validate: validateDuration},
+	{Name: "ingress-timeout-rules", Env: "INGRESS_TIMEOUT_RULES", Description: "space separated per route ingress timeouts", validate: validateTimeoutRuleList},
+	{Name: "egress-timeout-rules", Env: "EGRESS_TIMEOUT_RULES", Description: "space separated per route egress timeouts", validate: validateTimeoutRuleList},
+	{Name: "num-trusted-hops", Env: "NUM_TRUSTED_HOPS", Description: "number of trusted proxies in X-Forwarded-For", validate: validateCount},
+
+	{Name: "egress-retry-on", Env: "EGRESS_RETRY_ON", Description: "space separated egress retry conditions"},
+	{Name: "egress-retry-num-retries", Env: "EGRESS_RETRY_NUM_RETRIES", Description: "number of egress retries", validate: validateCount},
+	{Name: "egress-retry-per-try-timeout", Env: "EGRESS_RETRY_PER_TRY_TIMEOUT", Description: "timeout of each egress attempt", validate: validateDuration},
+	{Name: "egress-retry-hosts", Env: "EGRESS_RETRY_HOSTS", Description: "space separated hosts egress retries are limited to"},
+	{Name: "egress-retry-ports", Env: "EGRESS_RETRY_PORTS", Description: "space separated ports egress retries are limited to", validate: validatePortList(" ")},
+
+	{Name: "ingress-max-connections", Env: "INGRESS_MAX_CONNECTIONS", Description: "ingress circuit breaker connection limit", validate: validateCount},
+	{Name: "ingress-max-requests", Env: "INGRESS_MAX_REQUESTS", Description: "ingress circuit breaker request limit", validate: validateCount},
+	{Name: "egress-max-connections", Env: "EGRESS_MAX_CONNECTIONS", Description: "egress circuit breaker connection limit", validate: validateCount},
+	{Name: "egress-max-requests", Env: "EGRESS_MAX_REQUESTS", Description: "egress circuit breaker request limit", validate: validateCount},
+	{Name: "egress-outlier-consecutive-5xx", Env: "EGRESS_OUTLIER_CONSECUTIVE_5XX", Description: "5xx responses before an upstream host is ejected", validate: validateCount},
+
+	{Name: "cluster-connect-timeout", Env: "CLUSTER_CONNECT_TIMEOUT", Description: "upstream connect timeout", validate: validateDuration},
+	{Name: "cluster-idle-timeout", Env: "CLUSTER_IDLE_TIMEOUT", Description: "upstream connection idle timeout", validate: validateDuration},
+
+	{Name: "http2-upstream-protocol", Env: "HTTP2_UPSTREAM_PROTOCOL", Description: "http2 or downstream", validate: validateOneOf(UpstreamHTTP2, UpstreamDownstream)},
+	{Name: "upstream-protocol-ports", Env: "UPSTREAM_PROTOCOL_PORTS", Description: "space separated port=protocol upstream overrides", validate: validatePortUpstreamProtocols},
+	{Name: "http1-accept-http-10", Env: "HTTP1_ACCEPT_HTTP_10", Description: "accept HTTP/1.0 requests", validate: validateBool},
+	{Name: "http1-header-key-format", Env: "HTTP1_HEADER_KEY_FORMAT", Description: "default or proper_case", validate: validateOneOf(HeaderKeyFormatDefault, HeaderKeyFormatProperCase)},
+
+	{Name: "drain-type", Env: "DRAIN_TYPE", Description: "default or modify_only", validate: validateOneOf(DrainTypeDefault, DrainTypeModifyOnly)},
+	{Name: "drain-timeout", Env: "DRAIN_TIMEOUT", Description: "time HTTP/2 clients get to finish requests while draining", validate: validateDuration},
+
+	{Name: "access-log-path", Env: "ACCESS_LOG_PATH", Description: "file access logs are written to"},
+	{Name: "access-log-format", Env: "ACCESS_LOG_FORMAT", Description: "text or json", validate: validateOneOf(AccessLogFormatText, AccessLogFormatJSON)},
+	{Name: "access-log-filter", Env: "ACCESS_LOG_FILTER", Description: "all or errors", validate: validateOneOf(AccessLogFilterAll, AccessLogFilterErrors)},
+	{Name: "access-log-sample-percent", Env: "ACCESS_LOG_SAMPLE_PERCENT", Description: "percentage of requests that are logged", validate: validatePercentage},
+
+	{Name: "stats-sink", Env: "STATS_SINK", Description: "statsd or dogstatsd", validate: validateOneOf(StatsSinkStatsd, StatsSinkDogStatsd)},
+	{Name: "stats-sink-prefix", Env: "STATS_SINK_PREFIX", Description: "prefix of pushed metrics"},
+
+	{Name: "node-metadata", Env: "NODE_METADATA", Description: "space separated key=value node metadata", validate: validateKeyValues},
+	{Name: "downward-tag-labels", Env: "DOWNWARD_TAG_LABELS", Description: "space separated pod labels added to spans"},
+	{Name: "downward-tag-annotations", Env: "DOWNWARD_TAG_ANNOTATIONS", Description: "space separated pod annotations added to spans"},
+}
+
+// LookupAnnotation returns the annotation for a fully qualified key.
+func LookupAnnotation(key string) (Annotation, bool) {
+	if !strings.HasPrefix(key, AnnotationPrefix) {
+		return Annotation{}, false
+	}
+	name := strings.TrimPrefix(key, AnnotationPrefix)
+	for _, a := range Annotations {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Annotation{}, false
+}
+
+// Key returns the fully qualified annotation key.
+func (a Annotation) Key() string {
+	return AnnotationPrefix + a.Name
+}
+
+// Validate checks the annotation's value, naming the annotation in errors.
+func (a Annotation) Validate(value string) error {
+	if a.validate == nil {
+		return nil
+	}
+	if err := a.validate(value); err != nil {
+		return merry.Errorf("invalid value [%s] for annotation [%s]: %v", value, a.Key(), err)
+	}
+	return nil
+}
+
+// AnnotationValue is a validated annotation with its value.
+type AnnotationValue struct {
+	Annotation
+	Value string
+}
+
+// ParseAnnotations validates the observer annotations of a pod and returns
+// the ones setting environment variables, sorted by variable name. Other
+// annotations are ignored while unknown observer annotations are rejected.
+// Deprecated annotations are migrated with a warning.
+func ParseAnnotations(annotations map[string]string) ([]AnnotationValue, error) {
+	annotations, migrations := MigrateAnnotations(annotations)
+	for _, m := range migrations {
+		m.Warn()
+	}
+
+	values := []AnnotationValue{}
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, AnnotationPrefix) {
+			continue
+		}
+		a, ok := LookupAnnotation(key)
+		if !ok {
+			return nil, merry.Errorf("unknown annotation [%s]", key)
+		}
+		if err := a.Validate(value); err != nil {
+			return nil, err
+		}
+		if a.Env != "" {
+			values = append(values, AnnotationValue{Annotation: a, Value: value})
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Env < values[j].Env })
+	return values, nil
+}
+
+func validateBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+func validateDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return merry.New("duration cannot be negative")
+	}
+	return nil
+}
+
+func validateCount(value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return merry.New("value cannot be negative")
+	}
+	return nil
+}
+
+func validatePort(value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if i < 1 || i > 65535 {
+		return merry.New("port out of range")
+	}
+	return nil
+}
+
+func validatePortList(separator string) func(string) error {
+	return func(value string) error {
+		for _, port := range strings.Split(value, separator) {
+			if port = strings.TrimSpace(port); port == "" {
+				continue
+			}
+			if err := validatePort(port); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func validatePercentage(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if f < 0 || f > 100 {
+		return merry.New("percentage must be between 0 and 100")
+	}
+	return nil
+}
+
+func validateOneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return merry.Errorf("expected one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func validateTimeoutRuleList(value string) error {
+	rules, err := ParseTimeoutRules(strings.Fields(value))
+	if err != nil {
+		return err
+	}
+	return validateTimeoutRules(rules)
+}
+
+func validatePortUpstreamProtocols(value string) error {
+	_, err := ParsePortUpstreamProtocols(strings.Fields(value))
+	return err
+}
+
+func validateKeyValues(value string) error {
+	_, err := ParseKeyValues(strings.Fields(value))
+	return err
+}
@@ -0,0 +1,150 @@
+package options
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+	log "github.com/sirupsen/logrus"
+)
+
+// SchemaVersion is the version of the settings understood by this observer.
+// It is increased whenever a setting is renamed or changes format, together
+// with a migration from the previous versions.
+const SchemaVersion = 2
+
+// Migration describes a deprecated setting rewritten to its current form.
+type Migration struct {
+	Setting     string
+	Value       string
+	Replacement string
+	NewValue    string
+	// Since is the schema version that deprecated the setting
+	Since int
+	// Ignored is set when the replacement was also set and takes precedence
+	Ignored bool
+}
+
+// Warn logs the migration so that users update their settings.
+func (m Migration) Warn() {
+	fields := log.Fields{
+		"setting":        m.Setting,
+		"value":          m.Value,
+		"replacement":    m.Replacement,
+		"new_value":      m.NewValue,
+		"schema_version": m.Since,
+	}
+	if m.Ignored {
+		log.WithFields(fields).Warn("deprecated setting ignored in favour of its replacement")
+		return
+	}
+	log.WithFields(fields).Warn("deprecated setting migrated")
+}
+
+type renamedSetting struct {
+	name        string
+	replacement string
+	since       int
+}
+
+// Settings renamed in newer schema versions
+var renamedSettings = []renamedSetting{
+	{name: "TIMEOUT", replacement: "INGRESS_TIMEOUT", since: 2},
+}
+
+// Annotations renamed in newer schema versions, without the prefix
+var renamedAnnotations = []renamedSetting{
+	{name: "timeout", replacement: "ingress-timeout", since: 2},
+}
+
+// rename moves the values of renamed settings to their replacement unless
+// the replacement is set too.
+func rename(settings map[string]string, renamed []renamedSetting, prefix string) []Migration {
+	migrations := []Migration{}
+	for _, r := range renamed {
+		name, replacement := prefix+r.name, prefix+r.replacement
+		value := settings[name]
+		if value == "" {
+			continue
+		}
+		m := Migration{Setting: name, Value: value, Replacement: replacement, Since: r.since}
+		if settings[replacement] == "" {
+			settings[replacement] = value
+		} else {
+			m.Ignored = true
+		}
+		m.NewValue = settings[replacement]
+		delete(settings, name)
+		migrations = append(migrations, m)
+	}
+	return migrations
+}
+
+// Migrate rewrites deprecated settings to their current names and values.
+// Settings are keyed by their environment variable name without prefix and
+// are updated in place. Settings declaring a SCHEMA_VERSION newer than
+// SchemaVersion are rejected.
+func Migrate(settings map[string]string) ([]Migration, error) {
+	if v := settings["SCHEMA_VERSION"]; v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			return nil, merry.Errorf("invalid schema version [%s]", v)
+		}
+		if version > SchemaVersion {
+			return nil, merry.Errorf("settings use schema version [%d] but this observer only supports up to version [%d], upgrade the observer", version, SchemaVersion)
+		}
+	}
+
+	migrations := rename(settings, renamedSettings, "")
+	migrations = append(migrations, migrateTracingDriver(settings, "TRACING_DRIVER")...)
+
+	// Tag headers used to be separated by spaces
+	if headers := settings["TRACING_TAG_HEADERS"]; strings.ContainsAny(headers, " \t") {
+		settings["TRACING_TAG_HEADERS"] = strings.Join(ParseList(headers), ",")
+		migrations = append(migrations, Migration{
+			Setting:     "TRACING_TAG_HEADERS",
+			Value:       headers,
+			Replacement: "TRACING_TAG_HEADERS",
+			NewValue:    settings["TRACING_TAG_HEADERS"],
+			Since:       2,
+		})
+	}
+
+	return migrations, nil
+}
+
+// MigrateAnnotations returns a copy of the pod annotations with deprecated
+// observer annotations rewritten to their current names and values.
+func MigrateAnnotations(annotations map[string]string) (map[string]string, []Migration) {
+	migrated := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		migrated[key] = value
+	}
+	migrations := rename(migrated, renamedAnnotations, AnnotationPrefix)
+	migrations = append(migrations, migrateTracingDriver(migrated, AnnotationPrefix+"tracing-driver")...)
+	return migrated, migrations
+}
+
+// migrateTracingDriver fixes the jaeger driver, which used to be misspelled.
+func migrateTracingDriver(settings map[string]string, key string) []Migration {
+	driver := settings[key]
+	if !strings.EqualFold(driver, "jeager") {
+		return nil
+	}
+	settings[key] = "jaeger"
+	return []Migration{{
+		Setting:     key,
+		Value:       driver,
+		Replacement: key,
+		NewValue:    "jaeger",
+		Since:       2,
+	}}
+}
+
+// ParseList splits a comma separated list. Whitespace is accepted as a
+// separator for settings that were space separated in older schemas.
+func ParseList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
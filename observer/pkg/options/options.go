@@ -1,14 +1,20 @@
 package options
 
 import (
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ansel1/merry"
 )
 
-// Config ..
+// Options configure the generated Envoy config. They are built with New,
+// which validates them and applies defaults.
 type Options struct {
+	// SchemaVersion of the settings the options were built from
+	SchemaVersion int
+
 	TLSEnabled bool
 	TLSCACert  string
 	TLSCert    string
@@ -23,56 +29,634 @@ type Options struct {
 	TracingHost       string
 	TracingPort       int
 	TracingTagHeaders []string
+	// TracingTags are added to every span with a fixed value
+	TracingTags map[string]string
+	// TracingSampling is the percentage of requests that start a new trace
+	TracingSampling float64
+
+	// RuntimeDir holds the runtime values Envoy loads from disk, one file
+	// per key. Values set there override generated settings without a
+	// restart.
+	RuntimeDir string
+
+	// TimeoutDuration applies to incoming requests and EgressTimeoutDuration
+	// to outgoing requests. Timeout rules override them for matching requests.
+	TimeoutDuration       time.Duration
+	EgressTimeoutDuration time.Duration
+	IngressTimeoutRules   []TimeoutRule
+	EgressTimeoutRules    []TimeoutRule
+	TrustedHopsCount      int
+
+	EgressRetryPolicy RetryPolicy
+
+	IngressCircuitBreakers  CircuitBreakers
+	EgressCircuitBreakers   CircuitBreakers
+	IngressOutlierDetection OutlierDetection
+	EgressOutlierDetection  OutlierDetection
+
+	ClusterConnection ConnectionSettings
+
+	HTTP2 HTTP2Settings
+	HTTP1 HTTP1Settings
+
+	AccessLog AccessLog
+
+	Drain DrainSettings
+
+	// AdminAddress is the interface the admin API binds to. MetricsPort
+	// exposes only the Prometheus stats endpoint and is disabled when zero.
+	AdminAddress string
+	MetricsPort  int
+
+	ServiceName      string
+	ServiceNamespace string
+	ServiceVersion   string
+	StatsSink        StatsSink
+
+	Node Node
+}
+
+// Node identifies the proxy instance to Envoy. The service name is used as
+// the node's cluster.
+type Node struct {
+	ID       string
+	Region   string
+	Zone     string
+	SubZone  string
+	Metadata map[string]string
+}
+
+// ParseKeyValues parses entries in the form "key=value".
+func ParseKeyValues(specs []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, merry.Errorf("invalid entry [%s]: expected key=value", spec)
+		}
+		values[strings.TrimSpace(kv[0])] = kv[1]
+	}
+	return values, nil
+}
+
+// Stats sink types supported by StatsSink
+const (
+	StatsSinkStatsd    = "statsd"
+	StatsSinkDogStatsd = "dogstatsd"
+)
+
+// StatsSink pushes metrics to a statsd compatible agent over UDP. It is
+// disabled when Type is empty.
+type StatsSink struct {
+	Type    string
+	Address string
+	Port    int
+	Prefix  string
+}
+
+// Enabled reports whether a stats sink has been configured.
+func (s StatsSink) Enabled() bool {
+	return s.Type != ""
+}
+
+// Access log formats and filters supported by AccessLog
+const (
+	AccessLogFormatText   = "text"
+	AccessLogFormatJSON   = "json"
+	AccessLogFilterAll    = "all"
+	AccessLogFilterErrors = "errors"
+)
+
+// AccessLog configures request logging on every proxied filter chain. It is
+// disabled when Path is empty.
+type AccessLog struct {
+	Path          string
+	Format        string
+	Filter        string
+	SamplePercent int
+}
+
+// Enabled reports whether access logging has been configured.
+func (a AccessLog) Enabled() bool {
+	return a.Path != ""
+}
+
+// Listener drain types supported by DrainSettings
+const (
+	DrainTypeDefault    = "default"
+	DrainTypeModifyOnly = "modify_only"
+)
+
+// DrainSettings controls how the proxy listeners drain connections. With
+// the default type connections are drained once health checks fail, with
+// modify_only only when listeners are updated. Timeout is the time HTTP/2
+// clients get between the first and the final GOAWAY frame.
+type DrainSettings struct {
+	Type    string
+	Timeout time.Duration
+}
+
+// Header key formats supported by HTTP1Settings
+const (
+	HeaderKeyFormatDefault    = "default"
+	HeaderKeyFormatProperCase = "proper_case"
+)
+
+// HTTP1Settings configures the HTTP/1.1 codec used for HTTP/1 traffic.
+type HTTP1Settings struct {
+	AcceptHTTP10         bool
+	DefaultHostForHTTP10 string
+	AllowAbsoluteURL     bool
+	HeaderKeyFormat      string
+}
+
+// Upstream protocols supported by HTTP2Settings
+const (
+	UpstreamHTTP1      = "http1"
+	UpstreamHTTP2      = "http2"
+	UpstreamDownstream = "downstream"
+)
+
+// HTTP2Settings tunes HTTP/2 upstream connections. UpstreamProtocol selects
+// whether HTTP/2 requests are always forwarded over HTTP/2 or mirror the
+// downstream protocol. PortUpstreamProtocols forces the upstream protocol of
+// HTTP requests sent to specific destination ports.
+type HTTP2Settings struct {
+	MaxConcurrentStreams        int
+	InitialStreamWindowSize     int
+	InitialConnectionWindowSize int
+	UpstreamProtocol            string
+	PortUpstreamProtocols       map[int]string
+}
+
+// ParsePortUpstreamProtocols parses entries in the form "8080=http1".
+func ParsePortUpstreamProtocols(specs []string) (map[int]string, error) {
+	protocols := map[int]string{}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, merry.Errorf("invalid upstream protocol [%s]: expected port=protocol", spec)
+		}
+		port, err := strconv.Atoi(kv[0])
+		if err != nil {
+			return nil, merry.Errorf("invalid upstream protocol [%s]: %s", spec, err)
+		}
+		protocols[port] = kv[1]
+	}
+	return protocols, nil
+}
+
+// ConnectionSettings tunes the upstream connections opened by the proxy.
+// Zero values leave Envoy's defaults in place, except for ConnectTimeout which
+// is always required.
+type ConnectionSettings struct {
+	ConnectTimeout    time.Duration
+	IdleTimeout       time.Duration
+	KeepaliveProbes   int
+	KeepaliveTime     time.Duration
+	KeepaliveInterval time.Duration
+	CleanupInterval   time.Duration
+}
+
+// KeepaliveEnabled reports whether any TCP keepalive setting was configured.
+func (c ConnectionSettings) KeepaliveEnabled() bool {
+	return c.KeepaliveProbes > 0 || c.KeepaliveTime > 0 || c.KeepaliveInterval > 0
+}
+
+// CircuitBreakers limits the resources a single upstream cluster can consume.
+type CircuitBreakers struct {
+	MaxConnections     int
+	MaxPendingRequests int
+	MaxRequests        int
+	MaxRetries         int
+}
+
+// OutlierDetection ejects upstream hosts after consecutive failures. It is
+// disabled when Consecutive5xx is zero.
+type OutlierDetection struct {
+	Consecutive5xx     int
+	Interval           time.Duration
+	BaseEjectionTime   time.Duration
+	MaxEjectionPercent int
+}
+
+// Enabled reports whether outlier detection has been configured.
+func (o OutlierDetection) Enabled() bool {
+	return o.Consecutive5xx > 0
+}
+
+// RetryPolicy describes how failed upstream requests are retried. The policy
+// is disabled when RetryOn is empty. When Hosts or Ports are set the policy
+// only applies to requests addressed to those destinations.
+type RetryPolicy struct {
+	RetryOn       []string
+	NumRetries    int
+	PerTryTimeout time.Duration
+	BackOffBase   time.Duration
+	BackOffMax    time.Duration
+
+	Hosts []string
+	Ports []int
+}
+
+// Enabled reports whether any retry condition has been configured.
+func (p RetryPolicy) Enabled() bool {
+	return len(p.RetryOn) > 0
+}
+
+// Scoped reports whether the policy is restricted to specific destinations.
+func (p RetryPolicy) Scoped() bool {
+	return len(p.Hosts) > 0 || len(p.Ports) > 0
+}
+
+// TimeoutRule overrides the route timeout for requests matching all of the
+// configured criteria.
+type TimeoutRule struct {
+	PathPrefix string
+	Host       string
+	Port       int
+	Timeout    time.Duration
+}
+
+// ParseTimeoutRules parses rules in the form
+// "prefix=/reports,host=api,port=8080,timeout=30s". Every rule needs a
+// timeout and at least one of prefix, host or port.
+func ParseTimeoutRules(specs []string) ([]TimeoutRule, error) {
+	rules := []TimeoutRule{}
+	for _, spec := range specs {
+		rule := TimeoutRule{}
+		hasTimeout := false
+		for _, field := range strings.Split(spec, ",") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, merry.Errorf("invalid timeout rule [%s]: expected key=value pairs", spec)
+			}
+			var err error
+			switch kv[0] {
+			case "prefix":
+				rule.PathPrefix = kv[1]
+			case "host":
+				rule.Host = kv[1]
+			case "port":
+				rule.Port, err = strconv.Atoi(kv[1])
+			case "timeout":
+				rule.Timeout, err = time.ParseDuration(kv[1])
+				hasTimeout = true
+			default:
+				return nil, merry.Errorf("invalid timeout rule [%s]: unknown key %s", spec, kv[0])
+			}
+			if err != nil {
+				return nil, merry.Errorf("invalid timeout rule [%s]: %s", spec, err)
+			}
+		}
+		if !hasTimeout {
+			return nil, merry.Errorf("invalid timeout rule [%s]: missing timeout", spec)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func validateTimeoutRules(rules []TimeoutRule) error {
+	for _, r := range rules {
+		if r.PathPrefix == "" && r.Host == "" && r.Port == 0 {
+			return merry.New("timeout rules must match on at least one of path prefix, host or port")
+		}
+		if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+			return merry.Errorf("timeout rule path prefix [%s] must start with /", r.PathPrefix)
+		}
+		if r.Port < 0 || r.Port > 65535 {
+			return merry.Errorf("invalid timeout rule port [%d]", r.Port)
+		}
+		if r.Timeout < 0 {
+			return merry.New("timeout rule timeouts cannot be negative")
+		}
+	}
+	return nil
+}
+
+// Retry conditions understood by Envoy's router filter
+var retryConditions = map[string]bool{
+	"5xx":                    true,
+	"gateway-error":          true,
+	"reset":                  true,
+	"connect-failure":        true,
+	"retriable-4xx":          true,
+	"refused-stream":         true,
+	"retriable-status-codes": true,
+	"retriable-headers":      true,
+	"cancelled":              true,
+	"deadline-exceeded":      true,
+	"internal":               true,
+	"resource-exhausted":     true,
+	"unavailable":            true,
+}
+
+// New validates o and returns it with defaults applied to the settings
+// that were left empty.
+func New(o Options) (Options, error) {
+	o.SchemaVersion = SchemaVersion
 
-	TimeoutDuration  time.Duration
-	TrustedHopsCount int
-}
-
-func New(
-	ingressPort int,
-	egressPort int,
-	tracingDriver string,
-	tracingHost string,
-	tracingPort int,
-	tracingTagHeaders []string,
-	tlsEnabled bool,
-	tlsCACert string,
-	tlsCert string,
-	tlsKey string,
-	adminPort int,
-	adminLogPath string,
-	timeoutDuration time.Duration,
-	numTrustedHops int,
-) (Options, error) {
-	if tlsEnabled {
-		if tlsCert == "" || tlsKey == "" {
+	if o.TLSEnabled {
+		if o.TLSCert == "" || o.TLSKey == "" {
 			return Options{}, merry.New("TLS cannot be enabled without certificate cert and key")
 		}
 	}
 
+	if o.TracingSampling < 0 || o.TracingSampling > 100 {
+		return Options{}, merry.Errorf("invalid tracing sampling percentage [%v]", o.TracingSampling)
+	}
+
+	if o.TimeoutDuration < 0 || o.EgressTimeoutDuration < 0 {
+		return Options{}, merry.New("timeouts cannot be negative")
+	}
+	if err := validateTimeoutRules(o.IngressTimeoutRules); err != nil {
+		return Options{}, err
+	}
+	if err := validateTimeoutRules(o.EgressTimeoutRules); err != nil {
+		return Options{}, err
+	}
+
+	var err error
+	o.EgressRetryPolicy, err = normalizeRetryPolicy(o.EgressRetryPolicy)
+	if err != nil {
+		return Options{}, err
+	}
+
+	for _, cb := range []CircuitBreakers{o.IngressCircuitBreakers, o.EgressCircuitBreakers} {
+		if err := validateCircuitBreakers(cb); err != nil {
+			return Options{}, err
+		}
+	}
+	for _, od := range []OutlierDetection{o.IngressOutlierDetection, o.EgressOutlierDetection} {
+		if err := validateOutlierDetection(od); err != nil {
+			return Options{}, err
+		}
+	}
+
+	if err := validateConnectionSettings(o.ClusterConnection); err != nil {
+		return Options{}, err
+	}
+
+	if strings.TrimSpace(o.HTTP2.UpstreamProtocol) == "" {
+		o.HTTP2.UpstreamProtocol = UpstreamHTTP2
+	}
+	if err := validateHTTP2Settings(o.HTTP2); err != nil {
+		return Options{}, err
+	}
+
+	if strings.TrimSpace(o.HTTP1.HeaderKeyFormat) == "" {
+		o.HTTP1.HeaderKeyFormat = HeaderKeyFormatDefault
+	}
+	if err := validateHTTP1Settings(o.HTTP1); err != nil {
+		return Options{}, err
+	}
+
+	o.AccessLog, err = normalizeAccessLog(o.AccessLog)
+	if err != nil {
+		return Options{}, err
+	}
+
+	if strings.TrimSpace(o.Drain.Type) == "" {
+		o.Drain.Type = DrainTypeDefault
+	}
+	if err := validateDrainSettings(o.Drain); err != nil {
+		return Options{}, err
+	}
+
+	if strings.TrimSpace(o.AdminAddress) == "" {
+		o.AdminAddress = "127.0.0.1"
+	}
+	if o.MetricsPort < 0 || o.MetricsPort > 65535 {
+		return Options{}, merry.Errorf("invalid metrics port [%d]", o.MetricsPort)
+	}
+	if o.MetricsPort != 0 && (o.MetricsPort == o.AdminPort || o.MetricsPort == o.IngressPort || o.MetricsPort == o.EgressPort) {
+		return Options{}, merry.Errorf("metrics port [%d] conflicts with another proxy port", o.MetricsPort)
+	}
+
+	if err := validateStatsSink(o.StatsSink); err != nil {
+		return Options{}, err
+	}
+
+	o.ServiceName = strings.TrimSpace(o.ServiceName)
+	o.ServiceNamespace = strings.TrimSpace(o.ServiceNamespace)
+	o.ServiceVersion = strings.TrimSpace(o.ServiceVersion)
+	if o.ServiceName == "" {
+		return Options{}, merry.New("service name cannot be empty")
+	}
+	o.Node.ID = strings.TrimSpace(o.Node.ID)
+	if o.Node.ID == "" {
+		return Options{}, merry.New("node ID cannot be empty")
+	}
+	if o.Node.SubZone != "" && o.Node.Zone == "" || o.Node.Zone != "" && o.Node.Region == "" {
+		return Options{}, merry.New("node locality must be set from region down to sub zone")
+	}
+
 	// Defaulting to zipkin
-	if strings.Trim(tracingDriver, " ") == "" {
-		tracingDriver = "zipkin"
+	if strings.Trim(o.TracingDriver, " ") == "" {
+		o.TracingDriver = "zipkin"
 	}
 
-	return Options{
-		IngressPort: ingressPort,
-		EgressPort:  egressPort,
+	return o, nil
+}
 
-		TracingDriver:     tracingDriver,
-		TracingHost:       tracingHost,
-		TracingPort:       tracingPort,
-		TracingTagHeaders: tracingTagHeaders,
+func validateStatsSink(s StatsSink) error {
+	switch s.Type {
+	case "":
+		return nil
+	case StatsSinkStatsd, StatsSinkDogStatsd:
+	default:
+		return merry.Errorf(
+			"invalid stats sink [%s]. Supported values are: %s, %s",
+			s.Type, StatsSinkStatsd, StatsSinkDogStatsd,
+		)
+	}
+	// Envoy sends stats over UDP without resolving names
+	if net.ParseIP(s.Address) == nil {
+		return merry.Errorf("invalid stats sink address [%s]: must be an IP address", s.Address)
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		return merry.Errorf("invalid stats sink port [%d]", s.Port)
+	}
+	return nil
+}
 
-		TLSEnabled: tlsEnabled,
-		TLSCert:    tlsCert,
-		TLSCACert:  tlsCACert,
-		TLSKey:     tlsKey,
+func normalizeAccessLog(a AccessLog) (AccessLog, error) {
+	switch strings.TrimSpace(a.Path) {
+	case "":
+		return AccessLog{}, nil
+	case "stdout":
+		a.Path = "/dev/stdout"
+	case "stderr":
+		a.Path = "/dev/stderr"
+	}
+	if a.Format == "" {
+		a.Format = AccessLogFormatText
+	}
+	if a.Filter == "" {
+		a.Filter = AccessLogFilterAll
+	}
 
-		AdminPort:    adminPort,
-		AdminLogPath: adminLogPath,
+	if a.Format != AccessLogFormatText && a.Format != AccessLogFormatJSON {
+		return AccessLog{}, merry.Errorf(
+			"invalid access log format [%s]. Supported values are: %s, %s",
+			a.Format, AccessLogFormatText, AccessLogFormatJSON,
+		)
+	}
+	if a.Filter != AccessLogFilterAll && a.Filter != AccessLogFilterErrors {
+		return AccessLog{}, merry.Errorf(
+			"invalid access log filter [%s]. Supported values are: %s, %s",
+			a.Filter, AccessLogFilterAll, AccessLogFilterErrors,
+		)
+	}
+	if a.SamplePercent < 0 || a.SamplePercent > 100 {
+		return AccessLog{}, merry.Errorf("invalid access log sample percent [%d]", a.SamplePercent)
+	}
+	return a, nil
+}
 
-		TimeoutDuration:  timeoutDuration,
-		TrustedHopsCount: numTrustedHops,
-	}, nil
+func validateDrainSettings(d DrainSettings) error {
+	if d.Type != DrainTypeDefault && d.Type != DrainTypeModifyOnly {
+		return merry.Errorf(
+			"invalid drain type [%s]. Supported values are: %s, %s",
+			d.Type, DrainTypeDefault, DrainTypeModifyOnly,
+		)
+	}
+	if d.Timeout < 0 {
+		return merry.New("drain timeout cannot be negative")
+	}
+	return nil
+}
+
+func validateHTTP1Settings(h HTTP1Settings) error {
+	if h.DefaultHostForHTTP10 != "" && !h.AcceptHTTP10 {
+		return merry.New("a default host for HTTP/1.0 requires HTTP/1.0 to be accepted")
+	}
+	switch h.HeaderKeyFormat {
+	case HeaderKeyFormatDefault, HeaderKeyFormatProperCase:
+		return nil
+	case "preserve_case":
+		// The preserve_case formatter was only added in Envoy 1.19
+		return merry.New("header key format preserve_case is not supported by the bundled Envoy version")
+	}
+	return merry.Errorf(
+		"invalid header key format [%s]. Supported values are: %s, %s",
+		h.HeaderKeyFormat, HeaderKeyFormatDefault, HeaderKeyFormatProperCase,
+	)
+}
+
+func validateHTTP2Settings(h HTTP2Settings) error {
+	if h.MaxConcurrentStreams < 1 || h.MaxConcurrentStreams > 2147483647 {
+		return merry.Errorf("invalid HTTP/2 max concurrent streams [%d]", h.MaxConcurrentStreams)
+	}
+	// Envoy accepts window sizes between 65535 and 2^31 - 1
+	for _, size := range []int{h.InitialStreamWindowSize, h.InitialConnectionWindowSize} {
+		if size != 0 && (size < 65535 || size > 2147483647) {
+			return merry.Errorf("invalid HTTP/2 window size [%d]: must be between 65535 and 2147483647", size)
+		}
+	}
+	if h.UpstreamProtocol != UpstreamHTTP2 && h.UpstreamProtocol != UpstreamDownstream {
+		return merry.Errorf(
+			"invalid HTTP/2 upstream protocol [%s]. Supported values are: %s, %s",
+			h.UpstreamProtocol, UpstreamHTTP2, UpstreamDownstream,
+		)
+	}
+	for port, protocol := range h.PortUpstreamProtocols {
+		if port < 1 || port > 65535 {
+			return merry.Errorf("invalid upstream protocol port [%d]", port)
+		}
+		if protocol != UpstreamHTTP1 && protocol != UpstreamHTTP2 {
+			return merry.Errorf(
+				"invalid upstream protocol [%s] for port %d. Supported values are: %s, %s",
+				protocol, port, UpstreamHTTP1, UpstreamHTTP2,
+			)
+		}
+	}
+	return nil
+}
+
+func validateConnectionSettings(c ConnectionSettings) error {
+	if c.ConnectTimeout <= 0 {
+		return merry.New("cluster connect timeout must be positive")
+	}
+	if c.IdleTimeout < 0 || c.CleanupInterval < 0 {
+		return merry.New("cluster idle timeout and cleanup interval cannot be negative")
+	}
+	if c.KeepaliveProbes < 0 {
+		return merry.New("cluster keepalive probes cannot be negative")
+	}
+	// Envoy only accepts keepalive intervals in whole seconds
+	for _, d := range []time.Duration{c.KeepaliveTime, c.KeepaliveInterval} {
+		if d < 0 || d%time.Second != 0 {
+			return merry.Errorf("invalid cluster keepalive duration [%s]: must be a positive number of seconds", d)
+		}
+	}
+	return nil
+}
+
+func validateCircuitBreakers(cb CircuitBreakers) error {
+	if cb.MaxConnections < 1 || cb.MaxPendingRequests < 1 || cb.MaxRequests < 1 {
+		return merry.New("circuit breaker connection and request thresholds must be at least 1")
+	}
+	if cb.MaxRetries < 0 {
+		return merry.New("circuit breaker retry threshold cannot be negative")
+	}
+	return nil
+}
+
+func validateOutlierDetection(od OutlierDetection) error {
+	if od.Consecutive5xx < 0 {
+		return merry.New("outlier detection consecutive 5xx cannot be negative")
+	}
+	if !od.Enabled() {
+		return nil
+	}
+	if od.Interval <= 0 || od.BaseEjectionTime <= 0 {
+		return merry.New("outlier detection interval and base ejection time must be positive")
+	}
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		return merry.Errorf("invalid outlier detection max ejection percent [%d]", od.MaxEjectionPercent)
+	}
+	return nil
+}
+
+func normalizeRetryPolicy(p RetryPolicy) (RetryPolicy, error) {
+	// Conditions may be given either space or comma separated
+	conditions := []string{}
+	for _, c := range p.RetryOn {
+		for _, cond := range strings.Split(c, ",") {
+			cond = strings.TrimSpace(cond)
+			if cond == "" {
+				continue
+			}
+			if !retryConditions[cond] {
+				return RetryPolicy{}, merry.Errorf("invalid retry condition [%s]", cond)
+			}
+			conditions = append(conditions, cond)
+		}
+	}
+	p.RetryOn = conditions
+
+	if !p.Enabled() {
+		return RetryPolicy{}, nil
+	}
+
+	if p.NumRetries < 1 {
+		return RetryPolicy{}, merry.New("number of retries must be at least 1 when retries are enabled")
+	}
+	if p.PerTryTimeout < 0 || p.BackOffBase < 0 || p.BackOffMax < 0 {
+		return RetryPolicy{}, merry.New("retry timeouts and back off intervals cannot be negative")
+	}
+	if p.BackOffMax > 0 && p.BackOffMax < p.BackOffBase {
+		return RetryPolicy{}, merry.New("retry back off max interval cannot be smaller than the base interval")
+	}
+	if p.BackOffMax > 0 && p.BackOffBase == 0 {
+		return RetryPolicy{}, merry.New("retry back off max interval requires a base interval")
+	}
+	for _, port := range p.Ports {
+		if port < 1 || port > 65535 {
+			return RetryPolicy{}, merry.Errorf("invalid retry port [%d]", port)
+		}
+	}
+	return p, nil
 }
@@ -0,0 +1,230 @@
+package reload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ansel1/merry"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Generator builds the serialized Envoy config and returns the files it was
+// built from.
+type Generator func() (config []byte, files []string, err error)
+
+// Reloader regenerates the Envoy config when the files it was built from
+// change and replaces the config file when the result differs from the
+// running config.
+type Reloader struct {
+	ConfigPath string
+	Generate   Generator
+	// Validate checks a candidate config file before it replaces the
+	// running config
+	Validate func(path string) error
+	// Commit is called once a generated config has been accepted, either
+	// written or identical to the running config, so that state that must
+	// match the config is only published for accepted configs
+	Commit   func() error
+	Interval time.Duration
+	// RejectedPath, when set, holds the error of the last rejected config
+	// for as long as the running config is kept in its place
+	RejectedPath string
+
+	config      []byte
+	files       []string
+	fingerprint string
+}
+
+// Load generates and writes the initial config.
+func (r *Reloader) Load() error {
+	config, files, err := r.Generate()
+	if err != nil {
+		return err
+	}
+	if err := r.write(config); err != nil {
+		return err
+	}
+	r.accept()
+	r.config = config
+	r.files = files
+	r.fingerprint = fingerprint(files)
+	return r.commit()
+}
+
+// Check regenerates the config when the watched files changed and reports
+// whether a new config was written. Invalid configs are rejected so that the
+// last good config stays in place.
+func (r *Reloader) Check() (bool, error) {
+	current := fingerprint(r.files)
+	if current == r.fingerprint {
+		return false, nil
+	}
+	// Broken files are only reported once until they change again
+	r.fingerprint = current
+
+	config, files, err := r.Generate()
+	if err != nil {
+		return false, r.reject(err)
+	}
+	r.files = files
+	r.fingerprint = fingerprint(files)
+
+	if bytes.Equal(config, r.config) {
+		r.accept()
+		return false, r.commit()
+	}
+	if err := r.write(config); err != nil {
+		return false, r.reject(err)
+	}
+	r.accept()
+
+	log.WithField("sections", changedSections(r.config, config)).Info("envoy config changed")
+	r.config = config
+	return true, r.commit()
+}
+
+// Watch checks for changes every interval until stop is closed and sends
+// on reloads whenever a new config was written.
+func (r *Reloader) Watch(stop <-chan struct{}, reloads chan<- struct{}) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := r.Check()
+		if err != nil && !changed {
+			log.WithField("error", err.Error()).Error("rejected new envoy config, keeping the running config")
+			continue
+		}
+		if err != nil {
+			// The new config was written, so envoy still has to reload it
+			log.WithField("error", err.Error()).Error("failed to commit the new envoy config")
+		}
+		if !changed {
+			continue
+		}
+
+		select {
+		case <-stop:
+			return
+		case reloads <- struct{}{}:
+		}
+	}
+}
+
+// reject records why the config was rejected and returns err.
+func (r *Reloader) reject(err error) error {
+	if r.RejectedPath != "" {
+		if werr := WriteFile(r.RejectedPath, []byte(err.Error()+"\n"), nil); werr != nil {
+			log.WithField("error", werr.Error()).Warn("could not record the rejected envoy config")
+		}
+	}
+	return err
+}
+
+// accept clears the rejection recorded for a previous config.
+func (r *Reloader) accept() {
+	if r.RejectedPath == "" {
+		return
+	}
+	if err := os.Remove(r.RejectedPath); err != nil && !os.IsNotExist(err) {
+		log.WithField("error", err.Error()).Warn("could not clear the rejected envoy config")
+	}
+}
+
+func (r *Reloader) commit() error {
+	if r.Commit == nil {
+		return nil
+	}
+	return r.Commit()
+}
+
+// write atomically replaces the config file after validating the new
+// config.
+func (r *Reloader) write(config []byte) error {
+	return WriteFile(r.ConfigPath, config, r.Validate)
+}
+
+// WriteFile atomically replaces the file at path with content, so readers
+// watching the file never see a partial write. The content is written to a
+// temporary file in the same directory, checked with validate when it is
+// set, and renamed over path.
+func WriteFile(path string, content []byte, validate func(path string) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return merry.Wrap(err)
+	}
+	if err := f.Close(); err != nil {
+		return merry.Wrap(err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return merry.Wrap(err)
+	}
+
+	if validate != nil {
+		if err := validate(f.Name()); err != nil {
+			return err
+		}
+	}
+
+	return merry.Wrap(os.Rename(f.Name(), path))
+}
+
+// fingerprint hashes the content of files. Missing files hash differently
+// from empty files.
+func fingerprint(files []string) string {
+	h := sha256.New()
+	for _, path := range files {
+		h.Write([]byte(path))
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+			h.Write(content)
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// changedSections returns the top level config sections that differ.
+func changedSections(previous, next []byte) []string {
+	a := map[string]interface{}{}
+	b := map[string]interface{}{}
+	yaml.Unmarshal(previous, &a)
+	yaml.Unmarshal(next, &b)
+
+	sections := []string{}
+	for key := range b {
+		if !reflect.DeepEqual(a[key], b[key]) {
+			sections = append(sections, key)
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			sections = append(sections, key)
+		}
+	}
+	sort.Strings(sections)
+	return sections
+}
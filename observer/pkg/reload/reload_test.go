@@ -0,0 +1,258 @@
+package reload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newReloader returns a reloader generating the content of the options file
+// as config, failing on options containing "invalid".
+func newReloader(t *testing.T, dir string) (*Reloader, string, *int) {
+	optionsPath := filepath.Join(dir, "options")
+	require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 1\n"), 0644))
+
+	generated := 0
+	r := &Reloader{
+		ConfigPath: filepath.Join(dir, "envoy.yaml"),
+		Interval:   10 * time.Millisecond,
+		Generate: func() ([]byte, []string, error) {
+			generated++
+			content, err := ioutil.ReadFile(optionsPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			if strings.Contains(string(content), "invalid") {
+				return nil, nil, merry.New("invalid options")
+			}
+			return []byte(strings.TrimSuffix(string(content), "# comment\n")), []string{optionsPath}, nil
+		},
+	}
+	require.Nil(t, r.Load())
+	return r, optionsPath, &generated
+}
+
+func readConfig(t *testing.T, r *Reloader) string {
+	content, err := ioutil.ReadFile(r.ConfigPath)
+	require.Nil(t, err)
+	return string(content)
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	testDir := func(t *testing.T) string {
+		d, err := ioutil.TempDir(dir, "")
+		require.Nil(t, err)
+		return d
+	}
+
+	t.Run("Should write the initial config", func(t *testing.T) {
+		// When
+		r, _, _ := newReloader(t, testDir(t))
+
+		// Then
+		assert.Equal(t, "a: 1\n", readConfig(t, r))
+	})
+
+	t.Run("Should not regenerate unchanged files", func(t *testing.T) {
+		r, _, generated := newReloader(t, testDir(t))
+
+		// When
+		changed, err := r.Check()
+
+		// Then
+		assert.Nil(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, 1, *generated)
+	})
+
+	t.Run("Should write changed configs", func(t *testing.T) {
+		r, optionsPath, _ := newReloader(t, testDir(t))
+
+		// When
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 2\n"), 0644))
+		changed, err := r.Check()
+
+		// Then
+		assert.Nil(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "a: 2\n", readConfig(t, r))
+	})
+
+	t.Run("Should ignore changes that do not affect the config", func(t *testing.T) {
+		r, optionsPath, generated := newReloader(t, testDir(t))
+
+		// When
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 1\n# comment\n"), 0644))
+		changed, err := r.Check()
+
+		// Then
+		assert.Nil(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, 2, *generated)
+	})
+
+	t.Run("Should keep the last good config", func(t *testing.T) {
+		r, optionsPath, _ := newReloader(t, testDir(t))
+
+		// When
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("invalid\n"), 0644))
+		changed, err := r.Check()
+
+		// Then
+		assert.NotNil(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "a: 1\n", readConfig(t, r))
+
+		changed, err = r.Check()
+		assert.Nil(t, err, "Errors should only be reported once")
+		assert.False(t, changed)
+
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 3\n"), 0644))
+		changed, err = r.Check()
+		assert.Nil(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "a: 3\n", readConfig(t, r))
+	})
+
+	t.Run("Should reject configs failing validation", func(t *testing.T) {
+		r, optionsPath, _ := newReloader(t, testDir(t))
+		r.Validate = func(path string) error {
+			content, err := ioutil.ReadFile(path)
+			require.Nil(t, err)
+			if string(content) == "a: 4\n" {
+				return merry.New("rejected")
+			}
+			return nil
+		}
+
+		// When
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 4\n"), 0644))
+		changed, err := r.Check()
+
+		// Then
+		assert.NotNil(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "a: 1\n", readConfig(t, r))
+		files, err := ioutil.ReadDir(filepath.Dir(r.ConfigPath))
+		assert.Nil(t, err)
+		assert.Len(t, files, 2, "Candidate configs should be removed")
+	})
+
+	t.Run("Should record rejections until a config is accepted", func(t *testing.T) {
+		r, optionsPath, _ := newReloader(t, testDir(t))
+		r.RejectedPath = r.ConfigPath + ".rejected"
+
+		// When
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("invalid\n"), 0644))
+		_, err := r.Check()
+
+		// Then
+		assert.NotNil(t, err)
+		rejected, err := ioutil.ReadFile(r.RejectedPath)
+		assert.Nil(t, err)
+		assert.Equal(t, "invalid options\n", string(rejected))
+
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 1\n# comment\n"), 0644))
+		_, err = r.Check()
+		assert.Nil(t, err)
+		_, err = os.Stat(r.RejectedPath)
+		assert.True(t, os.IsNotExist(err), "Accepting the running config again should clear the rejection")
+	})
+
+	t.Run("Should only commit accepted configs", func(t *testing.T) {
+		r, optionsPath, _ := newReloader(t, testDir(t))
+		committed := []string{}
+		r.Commit = func() error {
+			committed = append(committed, readConfig(t, r))
+			return nil
+		}
+		r.Validate = func(path string) error {
+			content, err := ioutil.ReadFile(path)
+			require.Nil(t, err)
+			if string(content) == "a: 4\n" {
+				return merry.New("rejected")
+			}
+			return nil
+		}
+
+		// When
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 4\n"), 0644))
+		_, err := r.Check()
+		assert.NotNil(t, err)
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 1\n# comment\n"), 0644))
+		_, err = r.Check()
+		assert.Nil(t, err)
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 6\n"), 0644))
+		_, err = r.Check()
+		assert.Nil(t, err)
+
+		// Then
+		assert.Equal(t, []string{"a: 1\n", "a: 6\n"}, committed, "Rejected configs should not be committed")
+	})
+
+	t.Run("Should signal reloads while watching", func(t *testing.T) {
+		r, optionsPath, _ := newReloader(t, testDir(t))
+		stop := make(chan struct{})
+		defer close(stop)
+		reloads := make(chan struct{})
+		go r.Watch(stop, reloads)
+
+		// When
+		require.Nil(t, ioutil.WriteFile(optionsPath, []byte("a: 5\n"), 0644))
+
+		// Then
+		select {
+		case <-reloads:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload was signalled")
+		}
+		assert.Equal(t, "a: 5\n", readConfig(t, r))
+	})
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lds.yaml")
+
+	t.Run("Should create missing files", func(t *testing.T) {
+		// When
+		err := WriteFile(path, []byte("version_info: a\n"), nil)
+
+		// Then
+		require.Nil(t, err)
+		content, err := ioutil.ReadFile(path)
+		require.Nil(t, err)
+		assert.Equal(t, "version_info: a\n", string(content))
+	})
+
+	t.Run("Should replace files instead of rewriting them", func(t *testing.T) {
+		previous, err := os.Open(path)
+		require.Nil(t, err)
+		defer previous.Close()
+
+		// When
+		err = WriteFile(path, []byte("version_info: b\n"), nil)
+
+		// Then
+		require.Nil(t, err)
+		content, err := ioutil.ReadAll(previous)
+		require.Nil(t, err)
+		assert.Equal(t, "version_info: a\n", string(content), "Readers of the previous file should not see the new content")
+		content, err = ioutil.ReadFile(path)
+		require.Nil(t, err)
+		assert.Equal(t, "version_info: b\n", string(content))
+	})
+}
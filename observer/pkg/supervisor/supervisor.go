@@ -0,0 +1,290 @@
+package supervisor
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ansel1/merry"
+	log "github.com/sirupsen/logrus"
+)
+
+// Supervisor runs Envoy, restarting it with a backoff when it crashes and
+// draining it before forwarding termination signals.
+type Supervisor struct {
+	EnvoyPath string
+	Args      []string
+	// GID Envoy runs with. iptables rules exclude the proxy's own traffic by
+	// group so this must match the group used by the init container.
+	// Negative values keep the observer's group.
+	GID int
+	// AdminURL is used to fail health checks while draining
+	AdminURL string
+	// DrainTime is how long Envoy keeps serving after failing health checks
+	DrainTime time.Duration
+	// ShutdownTimeout is how long Envoy may take to exit once signalled
+	// before it is killed
+	ShutdownTimeout time.Duration
+	MinBackoff      time.Duration
+	MaxBackoff      time.Duration
+	// MaxRestarts is the number of consecutive crashes tolerated. Negative
+	// values restart Envoy forever.
+	MaxRestarts int
+	Stdout      io.Writer
+	Stderr      io.Writer
+}
+
+type process struct {
+	cmd   *exec.Cmd
+	epoch int
+	// exited is closed once the process exited with status
+	exited chan struct{}
+	status int
+}
+
+// running reports whether the process has not exited yet.
+func (p *process) running() bool {
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// Run starts Envoy and supervises it until it exits cleanly, crashes too
+// often or a signal is received. It returns Envoy's exit status. Values
+// received on reloads hot restart Envoy with the current config file.
+func (s *Supervisor) Run(signals <-chan os.Signal, reloads <-chan struct{}) (int, error) {
+	backoff := s.MinBackoff
+	restarts := 0
+	for {
+		started := time.Now()
+		status, stop, err := s.supervise(signals, reloads)
+		if err != nil {
+			return 1, err
+		}
+		if stop {
+			return status, nil
+		}
+		if status == 0 {
+			log.Info("envoy exited")
+			return 0, nil
+		}
+
+		// Crashes after a stable run start a new series of restarts
+		if time.Since(started) > s.MaxBackoff {
+			backoff = s.MinBackoff
+			restarts = 0
+		}
+		if s.MaxRestarts >= 0 && restarts >= s.MaxRestarts {
+			log.WithFields(log.Fields{"status": status, "restarts": restarts}).Error("envoy keeps crashing, giving up")
+			return status, nil
+		}
+		restarts++
+
+		log.WithFields(log.Fields{"status": status, "backoff": backoff.String()}).Warn("envoy crashed, restarting")
+		select {
+		case <-signals:
+			return status, nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// supervise starts Envoy and waits for it to exit, hot restarting it on
+// reloads. It returns the exit status and whether the supervisor should stop
+// because a signal was received.
+func (s *Supervisor) supervise(signals <-chan os.Signal, reloads <-chan struct{}) (int, bool, error) {
+	current, err := s.start(0)
+	if err != nil {
+		return 0, false, err
+	}
+	parents := []*process{}
+	// Parents are forgotten once they exited
+	parentExits := make(chan *process)
+	done := make(chan struct{})
+	defer close(done)
+
+	for {
+		select {
+		case sig := <-signals:
+			return s.shutdown(current, parents, signals, sig), true, nil
+
+		case <-reloads:
+			next, err := s.start(current.epoch + 1)
+			if err != nil {
+				log.WithField("error", err.Error()).Error("could not hot restart envoy")
+				continue
+			}
+			// The previous process drains and exits once the new one is ready
+			parents = append(parents, current)
+			go func(parent *process) {
+				<-parent.exited
+				select {
+				case parentExits <- parent:
+				case <-done:
+				}
+			}(current)
+			current = next
+
+		case parent := <-parentExits:
+			for i, p := range parents {
+				if p == parent {
+					parents = append(parents[:i], parents[i+1:]...)
+					log.WithFields(log.Fields{"status": p.status, "epoch": p.epoch}).Info("previous envoy exited")
+					break
+				}
+			}
+
+		case <-current.exited:
+			status := current.status
+			// A new epoch that fails to start leaves its parent serving
+			if status != 0 && len(parents) > 0 {
+				parent := parents[len(parents)-1]
+				parents = parents[:len(parents)-1]
+				if parent.running() {
+					log.WithFields(log.Fields{"status": status, "epoch": current.epoch}).Error("envoy hot restart failed, keeping the previous envoy")
+					current = parent
+					continue
+				}
+			}
+			for _, p := range parents {
+				if p.running() {
+					p.cmd.Process.Kill()
+				}
+			}
+			return status, false, nil
+		}
+	}
+}
+
+// args returns Envoy's arguments for a restart epoch. Parents drain for the
+// drain time once a new epoch is ready and are shut down after the
+// shutdown timeout. Envoy takes whole seconds, so durations are rounded up
+// rather than cutting the drain short.
+func (s *Supervisor) args(epoch int) []string {
+	drain := int(math.Ceil(s.DrainTime.Seconds()))
+	shutdown := int(math.Ceil((s.DrainTime + s.ShutdownTimeout).Seconds()))
+	if shutdown <= drain {
+		shutdown = drain + 1
+	}
+	return append(append([]string{}, s.Args...),
+		"--restart-epoch", strconv.Itoa(epoch),
+		"--drain-time-s", strconv.Itoa(drain),
+		"--parent-shutdown-time-s", strconv.Itoa(shutdown),
+	)
+}
+
+func (s *Supervisor) start(epoch int) (*process, error) {
+	args := s.args(epoch)
+	cmd := exec.Command(s.EnvoyPath, args...)
+	cmd.Stdout = s.Stdout
+	cmd.Stderr = s.Stderr
+	if s.GID >= 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid:         uint32(os.Getuid()),
+				Gid:         uint32(s.GID),
+				NoSetGroups: true,
+			},
+		}
+	}
+
+	log.WithFields(log.Fields{"args": args, "epoch": epoch}).Info("starting envoy")
+	if err := cmd.Start(); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	p := &process{cmd: cmd, epoch: epoch, exited: make(chan struct{})}
+	go func() {
+		p.status = exitStatus(cmd.Wait())
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// shutdown fails Envoy's health checks so that no new traffic is routed to
+// the pod, waits for the drain time and forwards the signal. A second
+// signal skips the remaining drain time.
+func (s *Supervisor) shutdown(current *process, parents []*process, signals <-chan os.Signal, sig os.Signal) int {
+	log.WithField("signal", sig.String()).Info("draining envoy")
+	if s.AdminURL != "" {
+		if err := failHealthChecks(s.AdminURL); err != nil {
+			log.WithField("error", err.Error()).Warn("could not fail envoy health checks")
+		}
+	}
+
+	select {
+	case <-current.exited:
+		return current.status
+	case <-signals:
+	case <-time.After(s.DrainTime):
+	}
+
+	live := []*process{}
+	epochs := []int{}
+	for _, p := range append(parents, current) {
+		if p.running() {
+			live = append(live, p)
+			epochs = append(epochs, p.epoch)
+		}
+	}
+	log.WithFields(log.Fields{"signal": sig.String(), "epochs": epochs}).Info("stopping envoy")
+	for _, p := range live {
+		if err := p.cmd.Process.Signal(sig); err != nil {
+			log.WithField("error", err.Error()).Warn("could not signal envoy")
+		}
+	}
+
+	select {
+	case <-current.exited:
+		return current.status
+	case <-time.After(s.ShutdownTimeout):
+		log.Warn("envoy did not stop in time, killing it")
+		for _, p := range live {
+			if p.running() {
+				p.cmd.Process.Kill()
+			}
+		}
+		<-current.exited
+		return current.status
+	}
+}
+
+func failHealthChecks(adminURL string) error {
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Post(adminURL+"/healthcheck/fail", "text/plain", nil)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return merry.Errorf("unexpected status [%d]", resp.StatusCode)
+	}
+	return nil
+}
+
+// exitStatus converts the result of a finished process into a shell style
+// exit status, where processes killed by a signal exit with 128 + signal.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	return 1
+}
@@ -0,0 +1,318 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSupervisor(t *testing.T, dir string, args ...string) *Supervisor {
+	envoyPath, err := filepath.Abs(filepath.Join("testdata", "fake-envoy"))
+	require.Nil(t, err)
+	return &Supervisor{
+		EnvoyPath:       envoyPath,
+		Args:            append([]string{args[0], dir}, args[1:]...),
+		GID:             -1,
+		DrainTime:       time.Second,
+		ShutdownTimeout: time.Second,
+		MinBackoff:      time.Millisecond,
+		MaxBackoff:      10 * time.Millisecond,
+		MaxRestarts:     3,
+		Stdout:          ioutil.Discard,
+		Stderr:          ioutil.Discard,
+	}
+}
+
+func countRuns(t *testing.T, dir string) int {
+	runs, err := ioutil.ReadFile(filepath.Join(dir, "runs"))
+	require.Nil(t, err)
+	return strings.Count(string(runs), "run\n")
+}
+
+func waitReady(t *testing.T, dir string, epoch int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(filepath.Join(dir, "ready-"+strconv.Itoa(epoch))); err == nil {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("fake envoy did not start")
+}
+
+func waitEvent(t *testing.T, dir string, event string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		events, _ := ioutil.ReadFile(filepath.Join(dir, "events"))
+		if strings.Contains(string(events), event+"\n") {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("fake envoy did not record %s", event)
+}
+
+func TestArgs(t *testing.T) {
+	t.Run("Should round drain times up to whole seconds", func(t *testing.T) {
+		s := &Supervisor{Args: []string{"-c", "envoy.yaml"}, DrainTime: 500 * time.Millisecond, ShutdownTimeout: time.Second}
+
+		// When
+		args := s.args(1)
+
+		// Then
+		assert.Equal(t, []string{
+			"-c", "envoy.yaml",
+			"--restart-epoch", "1",
+			"--drain-time-s", "1",
+			"--parent-shutdown-time-s", "2",
+		}, args)
+	})
+}
+
+func TestSupervisor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supervisor")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	testDir := func(t *testing.T) string {
+		d, err := ioutil.TempDir(dir, "")
+		require.Nil(t, err)
+		return d
+	}
+
+	t.Run("Should exit with envoy's status", func(t *testing.T) {
+		d := testDir(t)
+		s := newSupervisor(t, d, "exit", "0")
+
+		// When
+		status, err := s.Run(make(chan os.Signal), nil)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, 1, countRuns(t, d))
+	})
+
+	t.Run("Should restart envoy after crashes", func(t *testing.T) {
+		d := testDir(t)
+		s := newSupervisor(t, d, "crash-twice")
+
+		// When
+		status, err := s.Run(make(chan os.Signal), nil)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, 3, countRuns(t, d))
+	})
+
+	t.Run("Should give up after too many crashes", func(t *testing.T) {
+		d := testDir(t)
+		s := newSupervisor(t, d, "exit", "7")
+		s.MaxRestarts = 2
+
+		// When
+		status, err := s.Run(make(chan os.Signal), nil)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 7, status)
+		assert.Equal(t, 3, countRuns(t, d))
+	})
+
+	t.Run("Should drain and forward SIGTERM", func(t *testing.T) {
+		d := testDir(t)
+		mu := sync.Mutex{}
+		healthChecksFailed := false
+		admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			healthChecksFailed = r.Method == http.MethodPost && r.URL.Path == "/healthcheck/fail"
+		}))
+		defer admin.Close()
+
+		s := newSupervisor(t, d, "serve")
+		s.AdminURL = admin.URL
+		s.DrainTime = 100 * time.Millisecond
+
+		signals := make(chan os.Signal, 1)
+		go func() {
+			waitReady(t, d, 0)
+			signals <- syscall.SIGTERM
+		}()
+
+		// When
+		start := time.Now()
+		status, err := s.Run(signals, nil)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+		assert.True(t, time.Since(start) >= s.DrainTime)
+		mu.Lock()
+		assert.True(t, healthChecksFailed)
+		mu.Unlock()
+		events, err := ioutil.ReadFile(filepath.Join(d, "events"))
+		assert.Nil(t, err)
+		assert.Equal(t, "term 0\n", string(events))
+		assert.Equal(t, 1, countRuns(t, d))
+	})
+
+	t.Run("Should kill envoy when it does not stop", func(t *testing.T) {
+		d := testDir(t)
+		s := newSupervisor(t, d, "ignore-term")
+		s.DrainTime = 0
+		s.ShutdownTimeout = 100 * time.Millisecond
+
+		signals := make(chan os.Signal, 1)
+		go func() {
+			waitReady(t, d, 0)
+			signals <- syscall.SIGTERM
+		}()
+
+		// When
+		status, err := s.Run(signals, nil)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 128+int(syscall.SIGKILL), status)
+	})
+
+	t.Run("Should hot restart envoy on reloads", func(t *testing.T) {
+		d := testDir(t)
+		s := newSupervisor(t, d, "serve")
+		s.DrainTime = 0
+
+		signals := make(chan os.Signal, 1)
+		reloads := make(chan struct{})
+		go func() {
+			waitReady(t, d, 0)
+			reloads <- struct{}{}
+			waitReady(t, d, 1)
+			reloads <- struct{}{}
+			waitReady(t, d, 2)
+			waitEvent(t, d, "drained 1")
+			signals <- syscall.SIGTERM
+		}()
+
+		// When
+		status, err := s.Run(signals, reloads)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, 3, countRuns(t, d))
+		events, err := ioutil.ReadFile(filepath.Join(d, "events"))
+		assert.Nil(t, err)
+		assert.Contains(t, string(events), "drained 0\n")
+		assert.Contains(t, string(events), "drained 1\n")
+		assert.Contains(t, string(events), "term 2\n")
+	})
+
+	t.Run("Should only signal live envoys after hot restarts", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+		d := testDir(t)
+		s := newSupervisor(t, d, "serve")
+		s.DrainTime = 0
+
+		exitedParents := func() int {
+			exited := 0
+			for _, entry := range hook.AllEntries() {
+				if entry.Message == "previous envoy exited" {
+					exited++
+				}
+			}
+			return exited
+		}
+
+		signals := make(chan os.Signal, 1)
+		reloads := make(chan struct{})
+		go func() {
+			for epoch := 0; epoch < 3; epoch++ {
+				waitReady(t, d, epoch)
+				reloads <- struct{}{}
+			}
+			waitReady(t, d, 3)
+			deadline := time.Now().Add(5 * time.Second)
+			for exitedParents() < 3 && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			signals <- syscall.SIGTERM
+		}()
+
+		// When
+		status, err := s.Run(signals, reloads)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, 3, exitedParents())
+		var signalled interface{}
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "stopping envoy" {
+				signalled = entry.Data["epochs"]
+			}
+		}
+		assert.Equal(t, []int{3}, signalled)
+		events, err := ioutil.ReadFile(filepath.Join(d, "events"))
+		assert.Nil(t, err)
+		for epoch := 0; epoch < 3; epoch++ {
+			assert.Contains(t, string(events), "drained "+strconv.Itoa(epoch)+"\n")
+			assert.NotContains(t, string(events), "term "+strconv.Itoa(epoch)+"\n")
+		}
+		assert.Contains(t, string(events), "term 3\n")
+	})
+
+	t.Run("Should keep the previous envoy when a hot restart fails", func(t *testing.T) {
+		d := testDir(t)
+		s := newSupervisor(t, d, "fail-reload")
+		s.DrainTime = 0
+
+		signals := make(chan os.Signal, 1)
+		reloads := make(chan struct{})
+		go func() {
+			waitReady(t, d, 0)
+			reloads <- struct{}{}
+			for countRuns(t, d) < 2 {
+				time.Sleep(5 * time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+			signals <- syscall.SIGTERM
+		}()
+
+		// When
+		status, err := s.Run(signals, reloads)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Equal(t, 0, status)
+		assert.Equal(t, 2, countRuns(t, d))
+		events, err := ioutil.ReadFile(filepath.Join(d, "events"))
+		assert.Nil(t, err)
+		assert.Equal(t, "term 0\n", string(events))
+	})
+
+	t.Run("Failing: missing envoy binary", func(t *testing.T) {
+		s := newSupervisor(t, testDir(t), "exit", "0")
+		s.EnvoyPath = filepath.Join(dir, "missing")
+
+		// When
+		_, err := s.Run(make(chan os.Signal), nil)
+
+		// Then
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,223 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/omnition/omnition-observer/observer/pkg/inject"
+	log "github.com/sirupsen/logrus"
+)
+
+// Maximum size of an AdmissionReview request body
+const maxRequestBytes = 1 << 20
+
+type GroupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type AdmissionRequest struct {
+	UID       string           `json:"uid"`
+	Kind      GroupVersionKind `json:"kind"`
+	Namespace string           `json:"namespace,omitempty"`
+	Operation string           `json:"operation,omitempty"`
+	Object    json.RawMessage  `json:"object,omitempty"`
+}
+
+type Status struct {
+	Message string `json:"message,omitempty"`
+}
+
+type AdmissionResponse struct {
+	UID       string  `json:"uid"`
+	Allowed   bool    `json:"allowed"`
+	PatchType *string `json:"patchType,omitempty"`
+	Patch     []byte  `json:"patch,omitempty"`
+	Result    *Status `json:"status,omitempty"`
+}
+
+type AdmissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type containerName struct {
+	Name string `json:"name"`
+}
+
+type pod struct {
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		InitContainers []containerName `json:"initContainers"`
+		Containers     []containerName `json:"containers"`
+	} `json:"spec"`
+}
+
+// Handler patches pods submitted through AdmissionReview requests with the
+// observer containers.
+type Handler struct {
+	Config inject.Config
+}
+
+// NewServeMux returns a mux serving the webhook on /mutate and a liveness
+// check on /healthz.
+func NewServeMux(cfg inject.Config) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", &Handler{Config: cfg})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "expected application/json content", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	response := h.Review(review.Request)
+	serialized, err := json.Marshal(AdmissionReview{
+		APIVersion: review.APIVersion,
+		Kind:       review.Kind,
+		Response:   response,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(serialized)
+}
+
+// Review admits the requested object, patching pods that require the
+// observer. Pods with invalid observer annotations are rejected.
+func (h *Handler) Review(request *AdmissionRequest) *AdmissionResponse {
+	response := &AdmissionResponse{UID: request.UID, Allowed: true}
+	if request.Kind.Kind != "Pod" {
+		return response
+	}
+
+	patch, err := Patch(h.Config, request.Object)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"uid":       request.UID,
+			"namespace": request.Namespace,
+		}).Warn(err)
+		response.Allowed = false
+		response.Result = &Status{Message: err.Error()}
+		return response
+	}
+	if len(patch) == 0 {
+		return response
+	}
+
+	serialized, err := json.Marshal(patch)
+	if err != nil {
+		response.Allowed = false
+		response.Result = &Status{Message: err.Error()}
+		return response
+	}
+	patchType := "JSONPatch"
+	response.PatchType = &patchType
+	response.Patch = serialized
+	return response
+}
+
+// Patch returns the JSON patch operations that add the observer containers
+// to a serialized pod. The init container is appended last so that its
+// iptables rules are applied after every other init container has run.
+func Patch(cfg inject.Config, object []byte) ([]PatchOperation, error) {
+	p := pod{}
+	if err := json.Unmarshal(object, &p); err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	names := []string{}
+	for _, c := range p.Spec.InitContainers {
+		names = append(names, c.Name)
+	}
+	for _, c := range p.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	if !inject.Required(p.Metadata.Annotations, names) {
+		return nil, nil
+	}
+
+	initContainer, proxyContainer, err := inject.Sidecars(cfg, p.Metadata.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := []PatchOperation{}
+	if len(p.Spec.InitContainers) == 0 {
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  "/spec/initContainers",
+			Value: []inject.Container{initContainer},
+		})
+	} else {
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  "/spec/initContainers/-",
+			Value: initContainer,
+		})
+	}
+	patch = append(patch, PatchOperation{
+		Op:    "add",
+		Path:  "/spec/containers/-",
+		Value: proxyContainer,
+	})
+
+	if p.Metadata.Annotations == nil {
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations",
+			Value: map[string]string{inject.StatusAnnotation: "injected"},
+		})
+	} else {
+		patch = append(patch, PatchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + escapePointer(inject.StatusAnnotation),
+			Value: "injected",
+		})
+	}
+
+	return patch, nil
+}
+
+// escapePointer escapes a JSON pointer reference token as per RFC 6901.
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
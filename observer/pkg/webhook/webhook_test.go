@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/omnition/omnition-observer/observer/pkg/inject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+func review(t *testing.T, server *httptest.Server, fixture string) AdmissionReview {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	require.Nil(t, err)
+
+	resp, err := server.Client().Post(server.URL+"/mutate", "application/json", bytes.NewReader(body))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	result := AdmissionReview{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NotNil(t, result.Response)
+	return result
+}
+
+func decodePatch(t *testing.T, response *AdmissionResponse) []patchOperation {
+	patch := []patchOperation{}
+	require.Nil(t, json.Unmarshal(response.Patch, &patch))
+	return patch
+}
+
+func TestWebhook(t *testing.T) {
+	server := httptest.NewTLSServer(NewServeMux(inject.Config{
+		ProxyImage:      "omnition/omnition-observer:test",
+		InitImage:       "omnition/omnition-observer-init:test",
+		ImagePullPolicy: "Always",
+	}))
+	defer server.Close()
+
+	t.Run("Should append observer containers to pods", func(t *testing.T) {
+		// When
+		result := review(t, server, "pod.json")
+
+		// Then
+		assert.Equal(t, "admission.k8s.io/v1beta1", result.APIVersion)
+		assert.Equal(t, "AdmissionReview", result.Kind)
+		assert.Equal(t, "0df28fbd-5f5f-11e8-bc74-36e6bb280816", result.Response.UID)
+		assert.True(t, result.Response.Allowed)
+		assert.Equal(t, "JSONPatch", *result.Response.PatchType)
+
+		patch := decodePatch(t, result.Response)
+		assert.Len(t, patch, 3)
+
+		assert.Equal(t, "add", patch[0].Op)
+		assert.Equal(t, "/spec/initContainers/-", patch[0].Path)
+		initContainer := inject.Container{}
+		assert.Nil(t, json.Unmarshal(patch[0].Value, &initContainer))
+		assert.Equal(t, "omnition-observer-init", initContainer.Name)
+		assert.Equal(t, "omnition/omnition-observer-init:test", initContainer.Image)
+		assert.Equal(t, "Always", initContainer.ImagePullPolicy)
+		assert.Equal(t, []string{"NET_ADMIN"}, initContainer.SecurityContext.Capabilities.Add)
+		assert.True(t, *initContainer.SecurityContext.Privileged)
+		assert.Equal(t, []inject.EnvVar{
+			inject.EnvVar{Name: "INGRESS_EXCLUDE_PORTS", Value: "22"},
+		}, initContainer.Env)
+
+		assert.Equal(t, "add", patch[1].Op)
+		assert.Equal(t, "/spec/containers/-", patch[1].Path)
+		proxyContainer := inject.Container{}
+		assert.Nil(t, json.Unmarshal(patch[1].Value, &proxyContainer))
+		assert.Equal(t, "omnition-observer", proxyContainer.Name)
+		assert.Equal(t, "omnition/omnition-observer:test", proxyContainer.Image)
+		assert.Equal(t, []inject.ContainerPort{
+			inject.ContainerPort{Name: "metrics", ContainerPort: 15090},
+		}, proxyContainer.Ports)
+		assert.Equal(t, []inject.EnvVar{
+			inject.EnvVar{Name: "TRACING_HOST", Value: "zipkin.tracing"},
+			inject.EnvVar{Name: "TRACING_SAMPLING", Value: "10"},
+		}, proxyContainer.Env)
+
+		assert.Equal(t, "add", patch[2].Op)
+		assert.Equal(t, "/metadata/annotations/observer.omnition.io~1status", patch[2].Path)
+		assert.Equal(t, `"injected"`, string(patch[2].Value))
+	})
+
+	t.Run("Should create init containers on pods without any", func(t *testing.T) {
+		// When
+		result := review(t, server, "pod_without_init_containers.json")
+
+		// Then
+		assert.Equal(t, "admission.k8s.io/v1", result.APIVersion)
+		assert.True(t, result.Response.Allowed)
+
+		patch := decodePatch(t, result.Response)
+		assert.Len(t, patch, 3)
+
+		assert.Equal(t, "/spec/initContainers", patch[0].Path)
+		initContainers := []inject.Container{}
+		assert.Nil(t, json.Unmarshal(patch[0].Value, &initContainers))
+		assert.Len(t, initContainers, 1)
+		assert.Equal(t, "omnition-observer-init", initContainers[0].Name)
+		assert.Empty(t, initContainers[0].Env)
+
+		assert.Equal(t, "/spec/containers/-", patch[1].Path)
+
+		assert.Equal(t, "/metadata/annotations", patch[2].Path)
+		assert.Equal(t, `{"observer.omnition.io/status":"injected"}`, string(patch[2].Value))
+	})
+
+	t.Run("Should skip pods that opted out", func(t *testing.T) {
+		// When
+		result := review(t, server, "pod_opted_out.json")
+
+		// Then
+		assert.Equal(t, "a2c1e9c4-4f3d-4b8e-9d0b-5c0e1f6a7b8c", result.Response.UID)
+		assert.True(t, result.Response.Allowed)
+		assert.Nil(t, result.Response.PatchType)
+		assert.Empty(t, result.Response.Patch)
+	})
+
+	t.Run("Should reject pods with invalid annotations", func(t *testing.T) {
+		// When
+		result := review(t, server, "pod_invalid_annotation.json")
+
+		// Then
+		assert.False(t, result.Response.Allowed)
+		assert.Empty(t, result.Response.Patch)
+		assert.Contains(t, result.Response.Result.Message, "observer.omnition.io/sampling")
+	})
+
+	t.Run("Should reject malformed requests", func(t *testing.T) {
+		// When
+		resp, err := server.Client().Post(server.URL+"/mutate", "application/json", bytes.NewReader([]byte("{")))
+
+		// Then
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestPatch(t *testing.T) {
+	t.Run("Should skip pods that already run the observer", func(t *testing.T) {
+		// When
+		object := []byte(`{"spec": {"containers": [{"name": "app"}, {"name": "omnition-observer"}]}}`)
+		patch, err := Patch(inject.DefaultConfig(), object)
+
+		// Then
+		assert.Nil(t, err)
+		assert.Empty(t, patch)
+	})
+}
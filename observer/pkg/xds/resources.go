@@ -0,0 +1,128 @@
+package xds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ansel1/merry"
+	v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	trace "github.com/envoyproxy/go-control-plane/envoy/config/trace/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"gopkg.in/yaml.v2"
+
+	// Typed configs referenced from listeners and clusters have to be
+	// registered to be resolved.
+	_ "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v2"
+	_ "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	_ "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
+)
+
+// Listener converts a listener to its xDS representation.
+func Listener(l envoy.Listener) (*v2.Listener, error) {
+	listener := &v2.Listener{}
+	if err := toProto(l, listener); err != nil {
+		return nil, merry.Prepend(err, "listener "+l.Name)
+	}
+	return listener, nil
+}
+
+// Cluster converts a cluster to its xDS representation.
+func Cluster(c envoy.Cluster) (*v2.Cluster, error) {
+	cluster := &v2.Cluster{}
+	if err := toProto(c, cluster); err != nil {
+		return nil, merry.Prepend(err, "cluster "+c.Name)
+	}
+	return cluster, nil
+}
+
+// Secret converts a secret to its xDS representation.
+func Secret(s envoy.Secret) (*auth.Secret, error) {
+	secret := &auth.Secret{}
+	if err := toProto(s, secret); err != nil {
+		return nil, merry.Prepend(err, "secret "+s.Name)
+	}
+	return secret, nil
+}
+
+// Tracing converts the bootstrap tracing config to its proto
+// representation.
+func Tracing(t envoy.Tracing) (*trace.Tracing, error) {
+	tracing := &trace.Tracing{}
+	if err := toProto(t, tracing); err != nil {
+		return nil, merry.Prepend(err, "tracing")
+	}
+	return tracing, nil
+}
+
+// convert turns resources into the listener, cluster and secret protos
+// served by the control plane.
+func convert(resources envoy.Resources) (listeners, clusters, secrets []types.Resource, err error) {
+	for _, l := range resources.Listeners {
+		listener, err := Listener(l)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	for _, c := range resources.Clusters {
+		cluster, err := Cluster(c)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		clusters = append(clusters, cluster)
+	}
+	for _, s := range resources.Secrets {
+		secret, err := Secret(s)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return listeners, clusters, secrets, nil
+}
+
+// toProto converts a config struct to a proto through its YAML form, which
+// is the same representation Envoy parses for static configs.
+func toProto(v interface{}, pb proto.Message) error {
+	serialized, err := yaml.Marshal(v)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	var generic interface{}
+	if err := yaml.Unmarshal(serialized, &generic); err != nil {
+		return merry.Wrap(err)
+	}
+	encoded, err := json.Marshal(jsonValue(generic))
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(jsonpb.Unmarshal(bytes.NewReader(encoded), pb))
+}
+
+// jsonValue converts decoded YAML into values encoding/json accepts. Empty
+// typed configs, which Envoy treats as the default config of the extension,
+// are dropped since protobuf requires a type for them.
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			name := fmt.Sprint(key)
+			if typed, ok := item.(map[interface{}]interface{}); ok && name == "typed_config" && len(typed) == 0 {
+				continue
+			}
+			m[name] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		for i := range value {
+			value[i] = jsonValue(value[i])
+		}
+	}
+	return v
+}
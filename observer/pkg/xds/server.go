@@ -0,0 +1,155 @@
+package xds
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v2"
+	server "github.com/envoyproxy/go-control-plane/pkg/server/v2"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+)
+
+// ClusterName is the static cluster Envoy reaches the control plane through.
+const ClusterName = "xds_cluster"
+
+// ConfigSource returns the config source of resources served by the control
+// plane.
+func ConfigSource() envoy.ConfigSource {
+	return envoy.ConfigSource{
+		APIConfigSource: &envoy.APIConfigSource{
+			APIType: "GRPC",
+			GRPCServices: []envoy.GRPCService{
+				envoy.GRPCService{EnvoyGRPC: envoy.EnvoyGRPC{ClusterName: ClusterName}},
+			},
+		},
+	}
+}
+
+// Bootstrap splits cfg into a minimal bootstrap that fetches listeners,
+// clusters and secrets from the control plane listening on socket, and the
+// resources the control plane serves.
+func Bootstrap(cfg envoy.Config, socket string) (envoy.Config, envoy.Resources) {
+	bootstrap, resources := envoy.Split(cfg, ConfigSource())
+	resources.UseSDS(ConfigSource())
+
+	bootstrap.StaticResources.Clusters = append(bootstrap.StaticResources.Clusters, envoy.Cluster{
+		Name:           ClusterName,
+		ConnectTimeout: envoy.Duration(time.Second),
+		Type:           "STATIC",
+		LBPolicy:       "ROUND_ROBIN",
+		HTTP2ProtocolOptions: envoy.HTTP2ProtocolOptions{
+			MaxConcurrentStreams: 100,
+		},
+		Hosts: []envoy.ClusterHost{
+			envoy.ClusterHost{Pipe: &envoy.Pipe{Path: socket}},
+		},
+	})
+	return bootstrap, resources
+}
+
+// localNode maps every node to the same snapshot since the control plane
+// only serves the Envoy it runs next to.
+type localNode struct{}
+
+func (localNode) ID(*core.Node) string {
+	return ""
+}
+
+// Server serves listeners, clusters and secrets to the local Envoy. Every
+// update is published as a new snapshot version that Envoy applies without
+// a restart.
+type Server struct {
+	cache cache.SnapshotCache
+	grpc  *grpc.Server
+
+	mu        sync.Mutex
+	version   int
+	resources []byte
+}
+
+// NewServer creates a control plane without any resources. Envoy waits for
+// the first Update before it starts its listeners.
+func NewServer() *Server {
+	s := &Server{
+		cache: cache.NewSnapshotCache(false, localNode{}, log.StandardLogger()),
+		grpc:  grpc.NewServer(),
+	}
+	xds := server.NewServer(context.Background(), s.cache, nil)
+	v2.RegisterListenerDiscoveryServiceServer(s.grpc, xds)
+	v2.RegisterClusterDiscoveryServiceServer(s.grpc, xds)
+	discovery.RegisterSecretDiscoveryServiceServer(s.grpc, xds)
+	return s
+}
+
+// Update publishes resources unless they are already being served.
+func (s *Server) Update(resources envoy.Resources) error {
+	serialized, err := yaml.Marshal(&resources)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	listeners, clusters, secrets, err := convert(resources)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(serialized, s.resources) {
+		return nil
+	}
+
+	version := strconv.Itoa(s.version + 1)
+	snapshot := cache.NewSnapshot(version, nil, clusters, nil, listeners, nil)
+	snapshot.Resources[types.Secret] = cache.NewResources(version, secrets)
+	if err := s.cache.SetSnapshot("", snapshot); err != nil {
+		return merry.Wrap(err)
+	}
+	s.version++
+	s.resources = serialized
+
+	log.WithField("version", version).Info("published envoy resources")
+	return nil
+}
+
+// Version returns the version of the resources being served.
+func (s *Server) Version() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strconv.Itoa(s.version)
+}
+
+// Listen creates the unix socket the control plane is served on, replacing
+// a socket left behind by a previous run.
+func Listen(socket string) (net.Listener, error) {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, merry.Wrap(err)
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+	return l, nil
+}
+
+// Serve accepts connections on l until Stop is called.
+func (s *Server) Serve(l net.Listener) error {
+	return merry.Wrap(s.grpc.Serve(l))
+}
+
+// Stop closes the listener and all open streams.
+func (s *Server) Stop() {
+	s.grpc.Stop()
+}
@@ -0,0 +1,209 @@
+package xds
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v2 "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v2"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy"
+	"github.com/omnition/omnition-observer/observer/pkg/envoy/envoytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func clusterNames(clusters []envoy.Cluster) []string {
+	names := []string{}
+	for _, c := range clusters {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestBootstrap(t *testing.T) {
+	t.Run("Should only keep clusters the bootstrap depends on static", func(t *testing.T) {
+		cfg := envoytest.NewConfig(t, false, 100)
+
+		// When
+		bootstrap, resources := Bootstrap(cfg, "/tmp/xds.sock")
+
+		// Then
+		assert.Empty(t, bootstrap.StaticResources.Listeners)
+		assert.Equal(t, []string{"tracing_zipkin_cluster", ClusterName}, clusterNames(bootstrap.StaticResources.Clusters))
+		assert.Equal(t, "/tmp/xds.sock", bootstrap.StaticResources.Clusters[1].Hosts[0].Pipe.Path)
+		require.NotNil(t, bootstrap.DynamicResources)
+		assert.Equal(t, ClusterName, bootstrap.DynamicResources.LDSConfig.APIConfigSource.GRPCServices[0].EnvoyGRPC.ClusterName)
+		assert.Equal(t, ClusterName, bootstrap.DynamicResources.CDSConfig.APIConfigSource.GRPCServices[0].EnvoyGRPC.ClusterName)
+
+		assert.Equal(t, len(cfg.StaticResources.Listeners), len(resources.Listeners))
+		assert.Equal(t, len(cfg.StaticResources.Clusters)-1, len(resources.Clusters))
+		assert.Empty(t, resources.Secrets)
+	})
+
+	t.Run("Should serve TLS material as secrets", func(t *testing.T) {
+		cfg := envoytest.NewConfig(t, true, 100)
+
+		// When
+		_, resources := Bootstrap(cfg, "/tmp/xds.sock")
+
+		// Then
+		require.Equal(t, 2, len(resources.Secrets))
+		assert.Equal(t, envoy.ServerCertificateSecret, resources.Secrets[0].Name)
+		assert.Equal(t, "cert", resources.Secrets[0].TLSCertificate.CertificateChain.InlineString)
+		assert.Equal(t, "key", resources.Secrets[0].TLSCertificate.PrivateKey.InlineString)
+		assert.Equal(t, envoy.TrustedCASecret, resources.Secrets[1].Name)
+		assert.Equal(t, "ca", resources.Secrets[1].ValidationContext.TrustedCA.InlineString)
+
+		for _, l := range resources.Listeners {
+			for _, chain := range l.FilterChains {
+				if chain.TLSContext == nil {
+					continue
+				}
+				assert.Empty(t, chain.TLSContext.CommonTLSContext.TLSCertificates)
+				assert.Equal(t, envoy.ServerCertificateSecret, chain.TLSContext.CommonTLSContext.TLSCertificateSDSSecretConfigs[0].Name)
+			}
+		}
+
+		// The config the resources were split from is left untouched
+		assert.Equal(t, "cert", cfg.StaticResources.Listeners[0].FilterChains[0].TLSContext.CommonTLSContext.TLSCertificates[0].CertificateChain.InlineString)
+	})
+
+	t.Run("Should convert all resources", func(t *testing.T) {
+		_, resources := Bootstrap(envoytest.NewConfig(t, true, 100), "/tmp/xds.sock")
+
+		// When
+		listeners, clusters, secrets, err := convert(resources)
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, len(resources.Listeners), len(listeners))
+		assert.Equal(t, len(resources.Clusters), len(clusters))
+		assert.Equal(t, len(resources.Secrets), len(secrets))
+		assert.Equal(t, "ingress_listener", listeners[0].(*v2.Listener).Name)
+		assert.Equal(t, "h1_ingress_cluster", clusters[0].(*v2.Cluster).Name)
+	})
+}
+
+// startServer serves a control plane on a socket in dir and returns a
+// connection to it.
+func startServer(t *testing.T, dir string) (*Server, *grpc.ClientConn) {
+	socket := filepath.Join(dir, "xds.sock")
+	s := NewServer()
+	l, err := Listen(socket)
+	require.Nil(t, err)
+	go s.Serve(l)
+
+	conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+	}))
+	require.Nil(t, err)
+	return s, conn
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xds")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s, conn := startServer(t, dir)
+	defer s.Stop()
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, resources := Bootstrap(envoytest.NewConfig(t, true, 100), filepath.Join(dir, "xds.sock"))
+	require.Nil(t, s.Update(resources))
+
+	listeners, err := v2.NewListenerDiscoveryServiceClient(conn).StreamListeners(ctx)
+	require.Nil(t, err)
+	require.Nil(t, listeners.Send(&v2.DiscoveryRequest{TypeUrl: resource.ListenerType}))
+
+	var nonce string
+	t.Run("Should serve listeners", func(t *testing.T) {
+		// When
+		response, err := listeners.Recv()
+		nonce = response.GetNonce()
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, "1", response.VersionInfo)
+		assert.Equal(t, len(resources.Listeners), len(response.Resources))
+	})
+
+	t.Run("Should serve clusters", func(t *testing.T) {
+		stream, err := v2.NewClusterDiscoveryServiceClient(conn).StreamClusters(ctx)
+		require.Nil(t, err)
+
+		// When
+		require.Nil(t, stream.Send(&v2.DiscoveryRequest{TypeUrl: resource.ClusterType}))
+		response, err := stream.Recv()
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, len(resources.Clusters), len(response.Resources))
+	})
+
+	t.Run("Should serve secrets", func(t *testing.T) {
+		stream, err := discovery.NewSecretDiscoveryServiceClient(conn).StreamSecrets(ctx)
+		require.Nil(t, err)
+
+		// When
+		require.Nil(t, stream.Send(&v2.DiscoveryRequest{
+			TypeUrl:       resource.SecretType,
+			ResourceNames: []string{envoy.ServerCertificateSecret},
+		}))
+		response, err := stream.Recv()
+
+		// Then
+		require.Nil(t, err)
+		require.Equal(t, 1, len(response.Resources))
+		secret := &auth.Secret{}
+		require.Nil(t, ptypes.UnmarshalAny(response.Resources[0], secret))
+		assert.Equal(t, "cert", secret.GetTlsCertificate().GetCertificateChain().GetInlineString())
+	})
+
+	t.Run("Should not publish unchanged resources", func(t *testing.T) {
+		// When
+		err := s.Update(resources)
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, "1", s.Version())
+	})
+
+	t.Run("Should push updated resources", func(t *testing.T) {
+		// Acknowledge the first version
+		require.Nil(t, listeners.Send(&v2.DiscoveryRequest{TypeUrl: resource.ListenerType, VersionInfo: "1", ResponseNonce: nonce}))
+		_, updated := Bootstrap(envoytest.NewConfig(t, true, 10), filepath.Join(dir, "xds.sock"))
+
+		// When
+		require.Nil(t, s.Update(updated))
+		response, err := listeners.Recv()
+
+		// Then
+		require.Nil(t, err)
+		assert.Equal(t, "2", response.VersionInfo)
+		listener := &v2.Listener{}
+		for _, r := range response.Resources {
+			require.Nil(t, ptypes.UnmarshalAny(r, listener))
+			if listener.Name == "ingress_listener" {
+				break
+			}
+		}
+		require.Equal(t, "ingress_listener", listener.Name)
+		manager := &hcm.HttpConnectionManager{}
+		require.Nil(t, ptypes.UnmarshalAny(listener.FilterChains[0].Filters[0].GetTypedConfig(), manager))
+		assert.Equal(t, 10.0, manager.Tracing.RandomSampling.Value)
+	})
+}